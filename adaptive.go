@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+)
+
+// AdaptiveSmoothing is the weight of the newest sample in the moving averages of the adaptive interval
+const AdaptiveSmoothing = 0.3
+
+// AdaptiveInterval widens the execution interval when events arrive faster than the commands complete
+type AdaptiveInterval struct {
+	avgDuration time.Duration
+	avgGap      time.Duration
+	lastEvent   time.Time
+}
+
+// ObserveEvent records the arrival of a matching event
+func (a *AdaptiveInterval) ObserveEvent(now time.Time) {
+	if !a.lastEvent.IsZero() {
+		a.avgGap = movingAverage(a.avgGap, now.Sub(a.lastEvent))
+	}
+	a.lastEvent = now
+}
+
+// ObserveRun records how long a run of the commands took
+func (a *AdaptiveInterval) ObserveRun(duration time.Duration) {
+	a.avgDuration = movingAverage(a.avgDuration, duration)
+}
+
+// Interval returns the effective interval, the base interval unless the commands are lagging behind the events
+func (a *AdaptiveInterval) Interval(base time.Duration) time.Duration {
+	busy := a.avgGap > 0 && a.avgGap < a.avgDuration
+	if busy && a.avgDuration > base {
+		Logf("adaptive interval: commands take %s on average, events arrive every %s", a.avgDuration, a.avgGap)
+		return a.avgDuration
+	}
+	return base
+}
+
+func movingAverage(average time.Duration, sample time.Duration) time.Duration {
+	if average == 0 {
+		return sample
+	}
+	return time.Duration(AdaptiveSmoothing*float64(sample) + (1-AdaptiveSmoothing)*float64(average))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// watchesAttrib indicates a rule runs its commands for attrib events, the tailed file only reports modify events
+func (w *WatchService) watchesAttrib() bool {
+	if w.config.TailFile != "" {
+		return false
+	}
+	for _, rule := range w.rules {
+		if _, found := rule.watchFlags[AttribEvent.Name]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheAttributes caches the metadata of the files of the watched directories matching an attrib rule, so that the
+// first change of their mode or owner is reported with the previous values instead of as new
+func (w *WatchService) cacheAttributes() {
+	for _, dir := range w.WatchedDirs() {
+		// the entries are cached under the names of the events: the watched path, as given, joined with the name
+		if dir == filepath.Clean(w.path) {
+			dir = w.path
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			Logln(err)
+			continue
+		}
+		for _, info := range infos {
+			path := dir + string(os.PathSeparator) + info.Name()
+			if !info.Mode().IsRegular() || w.entries[path] != nil || !w.matchesAttribRule(path) {
+				continue
+			}
+			if entry, err := newFileEntry(path, w.hash); err == nil {
+				w.entries[path] = entry
+			}
+		}
+	}
+}
+
+func (w *WatchService) matchesAttribRule(path string) bool {
+	relative := w.relativeToRoot(path)
+	for _, rule := range w.rules {
+		if _, found := rule.watchFlags[AttribEvent.Name]; found && checkPatternMatching(rule.pattern, relative) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+// BenchSettleTime is how long the bench command waits for the last changes to be processed
+const BenchSettleTime = time.Duration(2) * time.Second
+
+// BenchResult is the JSON report of the bench command, the latencies are the time between a write and the run it
+// triggered, the writes merged into a single run count from the first one
+type BenchResult struct {
+	Writes     int     `json:"writes"`
+	Events     uint64  `json:"events"`
+	Runs       int     `json:"runs"`
+	Dropped    uint64  `json:"dropped"`
+	Overflow   uint64  `json:"overflow"`
+	Duplicates uint64  `json:"duplicates"`
+	Unmatched  int     `json:"writes_without_run"`
+	P50        float64 `json:"latency_p50_ms"`
+	P90        float64 `json:"latency_p90_ms"`
+	P99        float64 `json:"latency_p99_ms"`
+	Max        float64 `json:"latency_max_ms"`
+}
+
+// runBench writes files in a temporary directory at a fixed rate and watches it with the filters of the given options
+// and no command, then prints the latencies and the drop counters as JSON
+func runBench(args []string) (err error) {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	rate := flags.Float64("rate", 100, "The number of file writes per second")
+	files := flags.Int("files", 10, "The number of files written in turn")
+	duration := flags.Duration("duration", time.Duration(5)*time.Second, "How long to write the files")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *rate <= 0 || *files <= 0 {
+		return errors.New("the bench -rate and -files must be positive")
+	}
+
+	dir, err := ioutil.TempDir("", Program+"-bench")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWatchService(dir, benchConfig(resolveConfig()))
+	if err != nil {
+		return
+	}
+	var lock sync.Mutex
+	written := make(map[string]time.Time)
+	var latencies []time.Duration
+	w.EventTransform = func(evt *fsnotify.FileEvent) *fsnotify.FileEvent {
+		lock.Lock()
+		defer lock.Unlock()
+		path := filepath.Clean(evt.Name)
+		if first, found := written[path]; found {
+			latencies = append(latencies, time.Since(first))
+			delete(written, path)
+		}
+		return nil
+	}
+	if err = w.Start(); err != nil {
+		return
+	}
+
+	result := &BenchResult{}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	for deadline := time.Now().Add(*duration); time.Now().Before(deadline); <-ticker.C {
+		path := filepath.Join(dir, "file"+strconv.Itoa(result.Writes%*files))
+		lock.Lock()
+		if _, found := written[path]; !found {
+			written[path] = time.Now()
+		}
+		lock.Unlock()
+		if err = ioutil.WriteFile(path, []byte(strconv.Itoa(result.Writes)), 0644); err != nil {
+			break
+		}
+		result.Writes++
+	}
+	ticker.Stop()
+	time.Sleep(BenchSettleTime)
+	w.Stop()
+	if err != nil {
+		return
+	}
+
+	stats := w.Stats()
+	result.Events, result.Dropped, result.Overflow, result.Duplicates = stats.Events, stats.DroppedEvents, stats.OverflowEvents, stats.DuplicateEvents
+	result.Runs, result.Unmatched = len(latencies), len(written)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.P50, result.P90, result.P99 = percentileMillis(latencies, 50), percentileMillis(latencies, 90), percentileMillis(latencies, 99)
+		result.Max = percentileMillis(latencies, 100)
+	}
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// benchConfig keeps the options tuning the event handling (interval, overflow policy, dedup...) and drops the
+// commands and the options watching or writing other files
+func benchConfig(base *Config) *Config {
+	config := *base
+	config.Commands, config.DirCommands, config.DeleteCommands, config.OnFailure = nil, nil, nil, nil
+	config.Rules, config.IncludePattern, config.Events = nil, ".*", []string{"all"}
+	config.Recursive, config.Subtrees, config.TailFile, config.FromFile, config.WatchStdin = false, nil, "", "", false
+	config.LogFile, config.StateFile, config.PublishTo, config.HealthAddr, config.SyncTo = "", "", "", "", ""
+	config.WaitForPath, config.Duration, config.MaxRuns, config.Grep = 0, 0, 0, ""
+	config.NoSummary = true
+	return &config
+}
+
+// percentileMillis returns the percentile of the sorted latencies in milliseconds
+func percentileMillis(sorted []time.Duration, percentile int) float64 {
+	index := (len(sorted)*percentile+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+	return float64(sorted[index]) / float64(time.Millisecond)
+}
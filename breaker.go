@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+)
+
+// CircuitBreaker pauses a command after too many consecutive failures.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	trippedAt time.Time
+}
+
+// NewCircuitBreaker creates a CircuitBreaker, a threshold of 0 disables it
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Tripped indicates the command has failed too often and is cooling down
+func (b *CircuitBreaker) Tripped(now time.Time) bool {
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return false
+	}
+	return now.Sub(b.trippedAt) < b.cooldown
+}
+
+// Record updates the breaker with the result of a command execution, it returns true when the breaker has just tripped
+func (b *CircuitBreaker) Record(err error, now time.Time) bool {
+	if err == nil {
+		b.failures = 0
+		return false
+	}
+
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.trippedAt = now
+		return true
+	}
+	return false
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	// LogFormatText logs processed events as human readable lines (only with -V)
+	LogFormatText = "text"
+	// LogFormatJSON logs each processed event as a single JSON object
+	LogFormatJSON = "json"
+
+	// OverflowBlock waits for the worker when the event buffer is full
+	OverflowBlock = "block"
+	// OverflowDropOldest discards the oldest queued event when the event buffer is full
+	OverflowDropOldest = "drop-oldest"
+	// OverflowDropNewest discards the incoming event when the event buffer is full
+	OverflowDropNewest = "drop-newest"
+)
+
 var (
-	defaultConfig = &Config{Version: Version, Events: []string{"all"}, Commands: []string{}}
+	defaultConfig = &Config{Version: Version, Events: []string{"all"}, Commands: []string{}, FileMode: 0644}
 )
 
 // Config models the configuration for watchf
@@ -19,8 +37,88 @@ type Config struct {
 	Events         CommaStringSet
 	IncludePattern string
 	Commands       StringSet
+	DirCommands    StringSet
+	Rules          []RuleConfig
 	Interval       time.Duration
+	CountThreshold int
 	Version        string
+
+	FailureThreshold  int
+	FailureCooldown   time.Duration
+	EnvFile           string
+	LogFormat         string
+	NoWaitClose       bool
+	FromFile          string
+	OutputTemplate    string
+	TailFile          string
+	ExitOnError       bool
+	StartupGrace      time.Duration
+	OnOverflow        string
+	Subtrees          CommaStringSet
+	ShowMatch         bool
+	RemoteHost        string
+	RemoteUser        string
+	RemoteKey         string
+	SyncTo            string
+	SyncDelete        bool
+	AdaptiveInterval  bool
+	LogFile           string
+	CommandsFile      string
+	CloseStrategy     string
+	Nice              int
+	WatchSymlinks     bool
+	CommandTimeout    time.Duration
+	CommandTimeouts   map[string]time.Duration
+	NoSummary         bool
+	Duration          time.Duration
+	ExcludeExts       CommaStringSet
+	LazyRecursive     bool
+	CreateWindow      time.Duration
+	AtomicOutput      bool
+	StableFor         time.Duration
+	AllowedCommands   CommaStringSet
+	StateFile         string
+	CombineOutput     bool
+	ActiveHours       CommaStringSet
+	ShowEvents        bool
+	MaxRuns           int
+	MaxRunsSuccessful bool
+	FollowRename      bool
+	WatchStdin        bool
+	LatestWins        bool
+	FilterFile        string
+	FilterDefault     string
+	SnapshotContent   bool
+	DirCountThreshold int
+	AllowMissing      bool
+	MemLimit          ByteSize
+	ReadyFile         string
+	ReadyTimeout      time.Duration
+	Pty               bool
+	HistorySize       int
+	FileMode          FileMode
+	DeleteCommands    StringSet
+	WaitForPath       time.Duration
+	MinAge            time.Duration
+	MaxAge            time.Duration
+	OnFailure         StringSet
+	WatchConfig       bool
+	RateLimit         float64
+	Burst             int
+	Dedup             bool
+	DedupWindow       time.Duration
+	PublishTo         string
+	EventNames        map[string]string
+	Grep              string
+	GrepEach          bool
+	RunDelay          time.Duration
+	HealthAddr        string
+	Hash              string
+	HashStrategies    map[string]string
+	DiffSummary       bool
+	Workers           int
+	Timings           time.Duration
+	Target            string
 }
 
 // StringSet is a simple string array
@@ -31,10 +129,85 @@ type CommaStringSet []string
 
 func init() {
 	flag.BoolVar(&defaultConfig.Recursive, "r", false, "Watch directories recursively")
+	flag.BoolVar(&defaultConfig.LazyRecursive, "lazy", false, "With -r, watch a subdirectory only once something happens in its parent instead of walking the whole tree at startup (changes deep in the tree may be missed until then)")
 	flag.StringVar(&defaultConfig.IncludePattern, "p", ".*", "File name matches regular expression pattern (perl-style)")
 	flag.DurationVar(&defaultConfig.Interval, "i", time.Duration(0)*time.Millisecond, "The interval limit the frequency of the command executions, if equal to 0, there is no limit (time unit: ns/us/ms/s/m/h)")
+	flag.Float64Var(&defaultConfig.RateLimit, "rate-limit", 0, "Limit the runs of the commands to this many per second on average with a token bucket instead of -i, e.g. 0.2 for one every 5s")
+	flag.IntVar(&defaultConfig.Burst, "burst", 1, "With -rate-limit, the number of runs allowed in a row before the rate applies")
+	flag.StringVar(&defaultConfig.PublishTo, "publish-to", "", "Publish the matched events as JSON to a broker, nats://[user:pass@]host[:port]/subject or redis://[:pass@]host[:port]/channel (the topic defaults to "+Program+")")
+	flag.BoolVar(&defaultConfig.Dedup, "dedup", false, "Skip the events identical to an event still queued for the worker (same path and type), to save the content checks during event storms")
+	flag.DurationVar(&defaultConfig.DedupWindow, "dedup-window", time.Duration(100)*time.Millisecond, "With -dedup, how long a queued event absorbs the identical events (time unit: ns/us/ms/s/m/h)")
+	flag.IntVar(&defaultConfig.CountThreshold, "count-threshold", 0, "Run the commands once every N qualifying events, if -i is also set either one may trigger a run")
+	flag.BoolVar(&defaultConfig.LatestWins, "latest-wins", false, "Collapse the events queued while the commands run into a single run for the latest one")
+	flag.IntVar(&defaultConfig.DirCountThreshold, "dir-count", 0, "Run the commands once a directory holds this many files matching the pattern, instead of on each event (%f is the directory, %t is ENTRY_COUNT)")
+	flag.BoolVar(&defaultConfig.AdaptiveInterval, "adaptive-interval", false, "Widen the interval automatically while the commands cannot keep up with the events")
+	flag.DurationVar(&defaultConfig.MinAge, "min-age", time.Duration(0), "Skip the events of the files modified less than this long ago, delete and rename events are not checked (time unit: ns/us/ms/s/m/h)")
+	flag.DurationVar(&defaultConfig.MaxAge, "max-age", time.Duration(0), "Skip the events of the files modified more than this long ago, e.g. old files touched by backups, delete and rename events are not checked (time unit: ns/us/ms/s/m/h)")
+	flag.Var(&defaultConfig.ExcludeExts, "exclude-ext", "Skip the files with these extensions even if they match the pattern (comma separated list, e.g. tmp,swp)")
 	flag.Var(&defaultConfig.Events, "e", "Listen for specific event(s) (comma separated list)")
-	flag.Var(&defaultConfig.Commands, "c", "Add arbitrary command (repeatable)")
+	flag.Var(&defaultConfig.Commands, "c", "Add arbitrary command (repeatable, prefix with an interpreter such as \"sh:\" or \"python:\" to run it as a script, or give a JSON array such as [\"grep\", \"TODO list\", \"%f\"] to pass the arguments unsplit)")
+	flag.DurationVar(&defaultConfig.CreateWindow, "create-window", time.Duration(0), "Skip the modify of a new file following its create within this window, so a new file runs the commands once (time unit: ns/us/ms/s/m/h)")
+	flag.DurationVar(&defaultConfig.StableFor, "stable-for", time.Duration(0), "Run the commands once per created or modified file, after its size and content did not change for this long (time unit: ns/us/ms/s/m/h)")
+	flag.Var(&defaultConfig.ActiveHours, "active-hours", "Run the commands only inside these daily windows of local time, e.g. 09:00-18:00 (comma separated list, a window such as 22:00-06:00 spans midnight)")
+	flag.DurationVar(&defaultConfig.StartupGrace, "startup-grace", time.Duration(0), "Observe events but do not run commands for this long after startup (time unit: ns/us/ms/s/m/h)")
+	flag.DurationVar(&defaultConfig.CommandTimeout, "command-timeout", time.Duration(0), "Kill a command running longer than this, if equal to 0, there is no limit (time unit: ns/us/ms/s/m/h)")
+	flag.Var(&defaultConfig.AllowedCommands, "allow", "Refuse to run the commands whose program is not in this list of base names (comma separated list, e.g. go,make), all commands are allowed when empty")
+	flag.BoolVar(&defaultConfig.AllowMissing, "allow-missing", false, "Start even if the program of a command cannot be found in PATH (not checked for commands with an interpreter prefix or -remote) or a -subtrees directory does not exist")
+	flag.StringVar(&defaultConfig.CommandsFile, "commands-file", "", "Add the commands listed in a file, one per line (\"-\" reads stdin)")
+	flag.BoolVar(&defaultConfig.WatchConfig, "watch-config", false, "Reload the configuration file when it changes, an invalid configuration keeps the running one (set it in the configuration file)")
+	flag.Var(&defaultConfig.OnFailure, "on-failure", "Add arbitrary command run when a command fails, even with continue on error (repeatable, %cmd is the failed command and %code its exit code, also in $WATCHF_FAILED_COMMAND and $WATCHF_EXIT_CODE)")
+	flag.Var(&defaultConfig.DeleteCommands, "delete-command", "Add arbitrary command for delete and rename events (repeatable), %f is the path that is gone, these events run the -c commands when none is given")
+	flag.Var(&defaultConfig.DirCommands, "dc", "Add arbitrary command for directory events (repeatable), directory events run the -c commands when none is given")
+	flag.BoolVar(&defaultConfig.SnapshotContent, "snapshot", false, "Copy the changed file to a temporary file before running the commands and replace %s with its path, the copy is removed afterwards")
+	flag.StringVar(&defaultConfig.SyncTo, "sync-to", "", "Copy each changed file into this directory (keeping its relative path) before running the commands")
+	flag.BoolVar(&defaultConfig.SyncDelete, "sync-delete", false, "Also remove deleted or renamed files from the -sync-to directory")
+	flag.StringVar(&defaultConfig.RemoteHost, "remote", "", "Run the commands over SSH on a remote host ([user@]host[:port]), the host key must be in ~/.ssh/known_hosts, the arguments are quoted so shell syntax needs an interpreter prefix such as sh:")
+	flag.StringVar(&defaultConfig.RemoteUser, "remote-user", "", "The user for the remote host (default: the current user)")
+	flag.StringVar(&defaultConfig.RemoteKey, "remote-key", "", "The private key for the remote host (default: ~/.ssh/id_rsa)")
+	flag.BoolVar(&defaultConfig.ShowEvents, "show-events", false, "Show a line for each received event and whether it matched and ran the commands (quieter than -V)")
+	flag.BoolVar(&defaultConfig.ShowMatch, "show-match", false, "Show the pattern and event that triggered each run")
+	flag.BoolVar(&defaultConfig.WatchSymlinks, "watch-symlinks", false, "Check the symlinks in the watched directories every second and run the commands of the modify rules when one points to a new target (%t is ENTRY_RETARGET)")
+	flag.Var(&defaultConfig.Subtrees, "subtrees", "Watch only these subdirectories recursively (comma separated list, relative to the watched directory)")
+	flag.StringVar(&defaultConfig.TailFile, "tail", "", "Watch a single file and pipe the content appended on each modify to the commands' stdin")
+	flag.StringVar(&defaultConfig.HealthAddr, "health-addr", "", "Serve the /healthz and /readyz checks over HTTP on this address, e.g. :8080 (JSON with the watched directory count and the uptime)")
+	flag.DurationVar(&defaultConfig.RunDelay, "run-delay", 0, "Wait this long after deciding to run the commands before running them, unlike -i the delay is not reset by new events")
+	flag.StringVar(&defaultConfig.Grep, "grep", "", "With -tail, run the commands only when appended lines match this regex, the matching lines are piped to their stdin (%line is the last one)")
+	flag.BoolVar(&defaultConfig.GrepEach, "grep-each", false, "With -grep, run the commands once per matching line instead of once per event")
+	flag.BoolVar(&defaultConfig.FollowRename, "follow-rename", false, "With -tail, keep following the file created at the same path after the tailed file is renamed or removed, from its start (like tail -F)")
+	flag.BoolVar(&defaultConfig.WatchStdin, "stdin", false, "Watch the paths piped to stdin as they arrive, one per line (e.g. git ls-files | watchf -stdin ...), files and the entries of directories")
+	flag.StringVar(&defaultConfig.FilterFile, "filter-file", "", "Act upon the paths according to ordered \"include <glob>\" or \"exclude <glob>\" lines, the first matching rule wins")
+	flag.StringVar(&defaultConfig.FilterDefault, "filter-default", FilterInclude, "What -filter-file does with the paths matching no rule: include or exclude (directories are still walked)")
+	flag.StringVar(&defaultConfig.FromFile, "from-file", "", "Watch only the files listed in a manifest file (one path per line)")
+	flag.StringVar(&defaultConfig.OnOverflow, "on-overflow", OverflowBlock, "What to do when commands cannot keep up and the event buffer is full: block, drop-oldest or drop-newest (dropping may miss changes)")
+	flag.StringVar(&defaultConfig.OutputTemplate, "o", "", "Write the output of each command to a file named by the template, e.g. \"logs/%f.%t.log\" (supports variables)")
+	flag.DurationVar(&defaultConfig.Duration, "duration", time.Duration(0), "Stop after watching for this long, exiting with 1 if a command failed, if equal to 0, watch until stopped (time unit: ns/us/ms/s/m/h)")
+	flag.BoolVar(&defaultConfig.AtomicOutput, "atomic-output", false, "With -o, write the stdout of each command to a temporary file renamed to the output file when the command succeeds (replacing it, a failed command leaves it intact)")
+	flag.BoolVar(&defaultConfig.CombineOutput, "combine-output", false, "Write the stderr of the commands to their stdout as a single stream (also with -o -atomic-output)")
+	flag.IntVar(&defaultConfig.MaxRuns, "max-runs", 0, "Stop after running the commands this many times, exiting with 1 if a command failed, if equal to 0, there is no limit")
+	flag.BoolVar(&defaultConfig.MaxRunsSuccessful, "max-runs-successful", false, "Count only the runs whose commands all succeeded toward -max-runs")
+	flag.BoolVar(&defaultConfig.ExitOnError, "exit-on-error", false, "Stop "+Program+" with a non-zero exit status when the watcher reports an error (e.g. the watched directory was removed)")
+	flag.StringVar(&defaultConfig.StateFile, "state-file", "", "Keep the last execution time in this file, so that -i is not reset when "+Program+" restarts")
+	flag.StringVar(&defaultConfig.LogFile, "log-file", "", "Write the log and the output of the commands to a file (see the logs command)")
+	flag.BoolVar(&defaultConfig.NoSummary, "no-summary", false, "Do not log the summary of the events and commands on shutdown")
+	flag.StringVar(&defaultConfig.LogFormat, "log-format", LogFormatText, "The format of the event log: text or json")
+	flag.IntVar(&defaultConfig.FailureThreshold, "failure-threshold", 0, "Pause a command after this many consecutive failures, if equal to 0, the command is never paused. A successful manual run (SIGUSR2, %t is ENTRY_MANUAL) resumes it")
+	flag.BoolVar(&defaultConfig.DiffSummary, "diff-summary", false, "Keep the content of the text files up to 1M and count the lines added and removed by each change (%added and %removed, also in $WATCHF_LINES_ADDED and $WATCHF_LINES_REMOVED)")
+	flag.IntVar(&defaultConfig.Workers, "workers", 1, "Wait for the modified files to be closed and hash them in this many goroutines before the events are handled, the events keep their order and are still handled one at a time")
+	flag.DurationVar(&defaultConfig.Timings, "timings", time.Duration(0), "Log the count, average and maximum time of each filter check, of the waits for the files to be closed and of the hashing every interval and on shutdown, if equal to 0, they are not measured")
+	flag.StringVar(&defaultConfig.Target, "target", "", "The directory to watch, a bare path or a path prefixed by the scheme of its watch backend, e.g. file://./src (file, i.e. fsnotify, is the only backend, the default is the working directory)")
+	flag.StringVar(&defaultConfig.Hash, "hash", HashAdler32, "How to detect the content changes of the modified files: adler32, sha256 or size (size and modification time, the content is not read), the configuration file may map extensions to other strategies (HashStrategies)")
+	flag.StringVar(&defaultConfig.CloseStrategy, "close-strategy", CloseSizeStable, "How to detect that a modified file was closed before hashing it: size-stable, none or flock (unix only)")
+	flag.BoolVar(&defaultConfig.NoWaitClose, "no-wait-close", false, "Do not wait for a modified file to be closed before hashing it, same as -close-strategy=none (faster, but a half-written file may be hashed)")
+	flag.IntVar(&defaultConfig.Nice, "nice", 0, "Run the commands with this niceness, e.g. 10 for background builds (a priority class on windows, not applied to -remote)")
+	flag.Var(&defaultConfig.MemLimit, "mem-limit", "Limit the address space of each command, e.g. 512M or 2G, a command exceeding it fails (linux only, not applied to -remote)")
+	flag.StringVar(&defaultConfig.ReadyFile, "ready-file", "", "Consider a command done once it creates this file instead of when it exits (for commands starting servers), the file is removed before each command")
+	flag.DurationVar(&defaultConfig.WaitForPath, "wait-for-path", time.Duration(0), "Wait up to this long for a missing watched directory to be created at startup, retrying with backoff, if equal to 0, a missing directory fails at once (time unit: ns/us/ms/s/m/h)")
+	flag.Var(&defaultConfig.FileMode, "file-mode", "The permissions of the files "+Program+" writes: the configuration, pid, state, log and output files (octal)")
+	flag.IntVar(&defaultConfig.HistorySize, "history", 20, "Keep the last N processed events and whether they ran the commands in the -state-file (see the status command)")
+	flag.BoolVar(&defaultConfig.Pty, "pty", false, "Run the commands in a pseudo-terminal to keep their colors and progress output, stdout and stderr are merged (not supported on windows and -remote)")
+	flag.DurationVar(&defaultConfig.ReadyTimeout, "ready-timeout", time.Duration(30)*time.Second, "How long to wait for the -ready-file before the command fails (time unit: ns/us/ms/s/m/h)")
+	flag.StringVar(&defaultConfig.EnvFile, "env-file", "", "Load environment variables for the commands from a file of key=value lines")
+	flag.DurationVar(&defaultConfig.FailureCooldown, "failure-cooldown", time.Duration(30)*time.Second, "How long a paused command waits before it is allowed to run again (time unit: ns/us/ms/s/m/h)")
 }
 
 // GetDefaultConfig returns a pointer to default configuration
@@ -42,33 +215,259 @@ func GetDefaultConfig() *Config {
 	return defaultConfig
 }
 
+// ConfigFlags returns the flags reproducing the config, its values quoted for a shell, and the options that only
+// the configuration file can set
+func ConfigFlags(config *Config) (args []string, omitted []string) {
+	// the flags are bound to the default config, which holds the values of the config while they are formatted
+	saved := *defaultConfig
+	*defaultConfig = *config
+	defer func() { *defaultConfig = saved }()
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if programFlags[f.Name] {
+			return
+		}
+		switch value := f.Value.(type) {
+		case *StringSet:
+			for _, item := range *value {
+				args = append(args, "-"+f.Name, quoteFlagValue(item))
+			}
+		case *CommaStringSet:
+			if len(*value) > 0 && value.String() != f.DefValue {
+				args = append(args, "-"+f.Name, quoteFlagValue(strings.Join(*value, ",")))
+			}
+		default:
+			if value.String() == f.DefValue {
+				return
+			}
+			if getter, ok := value.(flag.Getter); ok && getter.Get() == true {
+				args = append(args, "-"+f.Name)
+			} else {
+				args = append(args, "-"+f.Name+"="+quoteFlagValue(value.String()))
+			}
+		}
+	})
+
+	if len(config.Rules) > 0 {
+		omitted = append(omitted, "Rules")
+	}
+	if len(config.CommandTimeouts) > 0 {
+		omitted = append(omitted, "CommandTimeouts")
+	}
+	if len(config.EventNames) > 0 {
+		omitted = append(omitted, "EventNames")
+	}
+	if len(config.HashStrategies) > 0 {
+		omitted = append(omitted, "HashStrategies")
+	}
+	return
+}
+
+// quoteFlagValue quotes the value for a shell when it holds spaces or special characters
+func quoteFlagValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n'\"\\$`*?[]{}()<>|&;!#~") {
+		return shellQuote(value)
+	}
+	return value
+}
+
 // WriteConfigToFile will persist a Config
 func WriteConfigToFile(config *Config) (err error) {
 	rawdata, err := json.MarshalIndent(&config, "", "	")
 	if err != nil {
 		return
 	}
-	err = ioutil.WriteFile(configFile, rawdata, 0644)
+	if err = ioutil.WriteFile(configFile, rawdata, os.FileMode(config.FileMode)); err != nil {
+		return
+	}
+	// an existing file keeps its permissions otherwise
+	err = os.Chmod(configFile, os.FileMode(config.FileMode))
 	return
 }
 
-// LoadConfigFromFile creates a Config from a persisted configuration file
+// LoadConfigFromFile creates a Config from a persisted configuration file, resolving the profile selected with -profile
 func LoadConfigFromFile() (newConfig *Config, err error) {
 	// TODO: check compatibility
+	// start from the defaults so that options missing from older files keep their default values, through a deep
+	// copy since Unmarshal reuses the arrays of the slices and would overwrite the defaults
 	newConfig = &Config{}
+	rawDefaults, err := json.Marshal(defaultConfig)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(rawDefaults, newConfig); err != nil {
+		return
+	}
 	rawdata, err := ioutil.ReadFile(configFile)
 	if err != nil {
 		return
 	}
 	err = json.Unmarshal(rawdata, newConfig)
+	if err != nil || profile == "" {
+		return
+	}
+
+	// the flat configuration is the default profile, a named profile overrides its options
+	var profiles struct {
+		Profiles map[string]json.RawMessage
+	}
+	if err = json.Unmarshal(rawdata, &profiles); err != nil {
+		return
+	}
+	rawProfile, found := profiles.Profiles[profile]
+	if !found {
+		err = fmt.Errorf("the profile %s was not found in %s", profile, configFile)
+		return
+	}
+	err = json.Unmarshal(rawProfile, newConfig)
+	return
+}
+
+// LoadCommandsFile reads one command per line, blank lines and lines starting with # are ignored
+func LoadCommandsFile(filename string) (commands []string, err error) {
+	var reader io.Reader = os.Stdin
+	if filename != "-" {
+		f, errOpen := os.Open(filename)
+		if errOpen != nil {
+			return nil, errOpen
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	err = scanner.Err()
 	return
 }
 
+// ByteSize is a number of bytes, accepting the K, M, G and T suffixes (powers of 1024)
+type ByteSize int64
+
+// String formats ByteSize
+func (b *ByteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+// Set parses a number of bytes such as 1048576, 512K, 512M or 2G
+func (b *ByteSize) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(value, suffix) || strings.HasSuffix(value, suffix+"B") {
+			multiplier = int64(1) << (10 * uint(i+1))
+			value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("the size %s is not a number of bytes", value)
+	}
+	*b = ByteSize(n * multiplier)
+	return nil
+}
+
+// UnmarshalJSON accepts a number of bytes or a string such as "512M"
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		return b.Set(value)
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*b = ByteSize(n)
+	return nil
+}
+
+// FileMode is the permissions of a file, written in octal such as 0600
+type FileMode os.FileMode
+
+// String formats FileMode in octal
+func (m *FileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+// Set parses permissions in octal such as 0600 or 640
+func (m *FileMode) Set(value string) error {
+	n, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || os.FileMode(n)&^os.ModePerm != 0 {
+		return fmt.Errorf("the file mode %s is not octal permissions such as 0600", value)
+	}
+	*m = FileMode(n)
+	return nil
+}
+
+// MarshalJSON writes the permissions as an octal string
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts an octal string such as "0600" or a number
+func (m *FileMode) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		return m.Set(value)
+	}
+	var n uint32
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*m = FileMode(n)
+	return nil
+}
+
 // String formats StringSet
 func (f *StringSet) String() string {
 	return fmt.Sprint([]string(*f))
 }
 
+// StructuredCommand is a command given as its program and arguments in the configuration file, the arguments are
+// never split
+type StructuredCommand struct {
+	Program string
+	Args    []string
+}
+
+// UnmarshalJSON accepts the commands as strings or as StructuredCommand objects, which are kept as JSON arrays
+func (f *StringSet) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	if items == nil {
+		*f = nil
+		return nil
+	}
+	set := make(StringSet, 0, len(items))
+	for _, item := range items {
+		var command string
+		if err := json.Unmarshal(item, &command); err == nil {
+			set = append(set, command)
+			continue
+		}
+		var structured StructuredCommand
+		if err := json.Unmarshal(item, &structured); err != nil {
+			return err
+		}
+		if structured.Program == "" {
+			return fmt.Errorf("the structured command %s has no Program", item)
+		}
+		encoded, _ := json.Marshal(append([]string{structured.Program}, structured.Args...))
+		set = append(set, string(encoded))
+	}
+	*f = set
+	return nil
+}
+
 // Set will append a string value to a StringSet
 func (f *StringSet) Set(value string) error {
 	*f = append(*f, value)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFlags(t *testing.T) {
+	config := *defaultConfig
+	config.Recursive = true
+	config.Events = []string{"create", "modify"}
+	config.IncludePattern = `\.go$`
+	config.Commands = []string{"go test ./...", "make"}
+	config.Interval = time.Second
+	config.EventNames = map[string]string{"ENTRY_CREATE": "create"}
+
+	all, omitted := ConfigFlags(&config)
+	var args []string
+	for _, arg := range all {
+		// the flags of the test binary share the command line
+		if !strings.HasPrefix(arg, "-test.") {
+			args = append(args, arg)
+		}
+	}
+	expected := []string{"-c", "'go test ./...'", "-c", "make", "-e", "create,modify", "-i=1s", `-p='\.go$'`, "-r"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+	if !reflect.DeepEqual(omitted, []string{"EventNames"}) {
+		t.Errorf("expected EventNames to be left out, got %q", omitted)
+	}
+	if defaultConfig.Recursive || len(defaultConfig.Commands) != 0 {
+		t.Error("the default config should be restored")
+	}
+}
+
+func TestStringSetUnmarshalJSON(t *testing.T) {
+	var commands StringSet
+	data := `["go vet", {"Program": "grep", "Args": ["-r", "TODO list", "%f"]}]`
+	if err := json.Unmarshal([]byte(data), &commands); err != nil {
+		t.Fatal(err)
+	}
+	expected := StringSet{"go vet", `["grep","-r","TODO list","%f"]`}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %q, got %q", expected, commands)
+	}
+	if err := json.Unmarshal([]byte(`[{"Args": ["-v"]}]`), &commands); err == nil {
+		t.Error("expected an error for a structured command without a program")
+	}
+}
+
+func TestLoadConfigFromFileProfiles(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"Recursive": true, "Commands": ["make"], "Profiles": {
+		"build": {"Commands": ["go build"], "IncludePattern": "\\.go$"},
+		"lint": {"Commands": ["golint"], "Recursive": false}}}`)
+	f.Close()
+	defer func(file, selected string) { configFile, profile = file, selected }(configFile, profile)
+	configFile = f.Name()
+
+	tests := []struct {
+		profile   string
+		commands  StringSet
+		pattern   string
+		recursive bool
+	}{
+		{"", StringSet{"make"}, defaultConfig.IncludePattern, true},
+		{"build", StringSet{"go build"}, `\.go$`, true},
+		{"lint", StringSet{"golint"}, defaultConfig.IncludePattern, false},
+	}
+	for _, test := range tests {
+		profile = test.profile
+		config, err := LoadConfigFromFile()
+		if err != nil {
+			t.Fatalf("%q: %v", test.profile, err)
+		}
+		if !reflect.DeepEqual(config.Commands, test.commands) || config.IncludePattern != test.pattern || config.Recursive != test.recursive {
+			t.Errorf("%q: expected %q, %s and %t, got %q, %s and %t", test.profile, test.commands, test.pattern, test.recursive,
+				config.Commands, config.IncludePattern, config.Recursive)
+		}
+	}
+
+	profile = "test"
+	if _, err := LoadConfigFromFile(); err == nil {
+		t.Error("a missing profile should be reported")
+	}
+}
@@ -1,13 +1,24 @@
 package daemon
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// StopCheckInterval is how often WaitStopped checks whether the process exited
+const StopCheckInterval = time.Duration(100) * time.Millisecond
+
+// ErrTokenMismatch is returned by Stop when the daemon was started with another token
+var ErrTokenMismatch = errors.New("the stop token does not match")
+
 // Daemon models a generic daemon
 type Daemon struct {
 	name       string
@@ -15,6 +26,16 @@ type Daemon struct {
 	foreground bool
 	running    bool
 	service    Service
+
+	// StopSignal is sent to a backgrounded daemon by Stop, os.Interrupt when nil
+	StopSignal os.Signal
+
+	// Token is hashed into the pid file by Start, Stop then refuses to signal the daemon without the same token
+	Token     string
+	tokenHash string
+
+	// FileMode is the permissions of the pid file, 0644 when 0
+	FileMode os.FileMode
 }
 
 // Service is managed by the Daemon
@@ -33,11 +54,23 @@ func (d *Daemon) Start() (err error) {
 	if d.IsRunning() {
 		return errors.New(d.name + " is already running")
 	}
-	if err = ioutil.WriteFile(d.getPidFilename(), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+	content := strconv.Itoa(os.Getpid())
+	if d.Token != "" {
+		content += "\n" + hashToken(d.Token)
+	}
+	mode := d.FileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err = ioutil.WriteFile(d.getPidFilename(), []byte(content), mode); err != nil {
+		return
+	}
+	if err = os.Chmod(d.getPidFilename(), mode); err != nil {
 		return
 	}
 
 	if err = d.service.Start(); err != nil {
+		os.Remove(d.getPidFilename())
 		return err
 	}
 	d.foreground = true
@@ -68,11 +101,21 @@ func (d *Daemon) IsRunning() bool {
 func (d *Daemon) readPidFromFile(filename string) (pid int, err error) {
 	data, err := ioutil.ReadFile(filename)
 	if err == nil {
-		pid, err = strconv.Atoi(string(data))
+		lines := strings.SplitN(string(data), "\n", 2)
+		pid, err = strconv.Atoi(lines[0])
+		if len(lines) > 1 {
+			d.tokenHash = strings.TrimSpace(lines[1])
+		}
 	}
 	return
 }
 
+// hashToken returns the hex encoded SHA-256 of the token, the pid file never holds the token itself
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // Stop the Daemon
 func (d *Daemon) Stop() (err error) {
 	if !d.IsRunning() {
@@ -94,13 +137,15 @@ func (d *Daemon) Stop() (err error) {
 		return
 	}
 
-	var process *os.Process
-	process, err = os.FindProcess(d.pid)
-	if err != nil {
-		return
+	if d.tokenHash != "" && subtle.ConstantTimeCompare([]byte(hashToken(d.Token)), []byte(d.tokenHash)) != 1 {
+		return fmt.Errorf("%s was started with a stop token: %w", d.name, ErrTokenMismatch)
 	}
-	err = process.Signal(os.Interrupt)
-	if err != nil {
+
+	stopSignal := d.StopSignal
+	if stopSignal == nil {
+		stopSignal = os.Interrupt
+	}
+	if err = signalProcess(d.name, d.pid, stopSignal); err != nil {
 		return
 	}
 
@@ -112,6 +157,35 @@ func (d *Daemon) Stop() (err error) {
 	return
 }
 
+// NotifyStop relays the stop requests of Stop from other processes to c once the daemon started, the signals reach
+// the process directly on unix, windows sets a named event instead
+func (d *Daemon) NotifyStop(c chan<- os.Signal) error {
+	return notifyStop(d.name, d.pid, c)
+}
+
+// WaitStopped waits until the process of a backgrounded daemon exits, Stop only signals it
+func (d *Daemon) WaitStopped(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for isOSProcessRunning(d.pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the process:%d is still running after %s", d.pid, timeout)
+		}
+		time.Sleep(StopCheckInterval)
+	}
+	d.running = false
+	return nil
+}
+
+// ParseSignal returns the signal named e.g. "TERM" or "SIGTERM" (case insensitive)
+func ParseSignal(name string) (sig os.Signal, err error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	sig, found := signals[name]
+	if !found {
+		err = fmt.Errorf("the signal %s was not found", name)
+	}
+	return
+}
+
 // GetPid returns the Daemon's pid
 func (d *Daemon) GetPid() int {
 	return d.pid
@@ -2,9 +2,36 @@
 
 package daemon
 
-import "syscall"
+import (
+	"os"
+	"syscall"
+)
+
+// signals are the signals accepted by ParseSignal
+var signals = map[string]os.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"KILL": syscall.SIGKILL,
+}
 
 func isOSProcessRunning(pid int) (running bool) {
 	err := syscall.Kill(pid, 0)
 	return err == nil
 }
+
+// notifyStop does nothing, the stop signal reaches the process directly
+func notifyStop(name string, pid int, c chan<- os.Signal) error {
+	return nil
+}
+
+func signalProcess(name string, pid int, sig os.Signal) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"errors"
 	"os"
 	"testing"
 )
@@ -50,3 +51,32 @@ func TestForegroundDaemonStartAndStop(t *testing.T) {
 		t.Fatal("stopped: service and daemon have different running state")
 	}
 }
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"INT", "sigterm", "Kill"} {
+		if _, err := ParseSignal(name); err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+	}
+	if _, err := ParseSignal("BOGUS"); err == nil {
+		t.Error("BOGUS: expected an error")
+	}
+}
+
+func TestStopToken(t *testing.T) {
+	dmon := NewDaemon("dummy", &DummyService{})
+	dmon.Token = "secret"
+	if err := dmon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer dmon.Stop()
+
+	other := NewDaemon("dummy", nil)
+	other.Token = "guess"
+	if err := other.Stop(); !errors.Is(err, ErrTokenMismatch) {
+		t.Fatalf("expected the stop to be refused with %q, got %v", ErrTokenMismatch, err)
+	}
+	if other.tokenHash == "" || other.tokenHash == dmon.Token {
+		t.Errorf("expected the pid file to hold a hash of the token, got %q", other.tokenHash)
+	}
+}
@@ -2,9 +2,97 @@
 
 package daemon
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	eventModifyState               = 0x0002
+	stillActive                    = 259
+)
+
+var (
+	kernel32        = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEvent = kernel32.NewProc("CreateEventW")
+	procOpenEvent   = kernel32.NewProc("OpenEventW")
+	procSetEvent    = kernel32.NewProc("SetEvent")
+)
+
+// signals are the signals accepted by ParseSignal, windows has no signals for other processes: INT and TERM set the
+// stop event of the daemon, KILL terminates it
+var signals = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"TERM": os.Interrupt,
+	"KILL": os.Kill,
+}
 
 func isOSProcessRunning(pid int) (running bool) {
-	_, err := os.FindProcess(pid)
-	return err == nil
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// the process of another user exists but cannot be queried
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	if err = syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// stopEventName names the event of the session that stops the daemon of the pid
+func stopEventName(name string, pid int) (*uint16, error) {
+	return syscall.UTF16PtrFromString(`Local\` + name + "-stop-" + strconv.Itoa(pid))
+}
+
+// notifyStop creates the stop event of the daemon and sends os.Interrupt to c once another process sets it
+func notifyStop(name string, pid int, c chan<- os.Signal) error {
+	eventName, err := stopEventName(name, pid)
+	if err != nil {
+		return err
+	}
+	handle, _, errCreate := procCreateEvent.Call(0, 1, 0, uintptr(unsafe.Pointer(eventName)))
+	if handle == 0 {
+		return fmt.Errorf("cannot create the stop event: %v", errCreate)
+	}
+
+	go func() {
+		defer syscall.CloseHandle(syscall.Handle(handle))
+		event, err := syscall.WaitForSingleObject(syscall.Handle(handle), syscall.INFINITE)
+		if err == nil && event == syscall.WAIT_OBJECT_0 {
+			c <- os.Interrupt
+		}
+	}()
+	return nil
+}
+
+// signalProcess sets the stop event of the daemon, os.Kill terminates it without letting it clean up
+func signalProcess(name string, pid int, sig os.Signal) error {
+	if sig == os.Kill {
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		return process.Kill()
+	}
+
+	eventName, err := stopEventName(name, pid)
+	if err != nil {
+		return err
+	}
+	handle, _, errOpen := procOpenEvent.Call(eventModifyState, 0, uintptr(unsafe.Pointer(eventName)))
+	if handle == 0 {
+		return fmt.Errorf("the process:%d does not accept stop requests: %v", pid, errOpen)
+	}
+	defer syscall.CloseHandle(syscall.Handle(handle))
+	if ok, _, errSet := procSetEvent.Call(handle); ok == 0 {
+		return fmt.Errorf("cannot set the stop event of the process:%d: %v", pid, errSet)
+	}
+	return nil
 }
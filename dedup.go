@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+// DedupPruneSize is the number of queued event keys above which the expired ones are removed, the keys of the events
+// dropped by the overflow policy are never dequeued
+const DedupPruneSize = 4096
+
+// isQueuedDuplicate indicates an identical event (same path and type) was queued within the dedup window and was not
+// processed yet, the queued event reads the latest state of the file anyway
+func (w *WatchService) isQueuedDuplicate(evt *fsnotify.FileEvent, now time.Time) bool {
+	key := getEventType(evt) + " " + evt.Name
+	w.queuedLock.Lock()
+	defer w.queuedLock.Unlock()
+
+	if queuedAt, found := w.queued[key]; found && now.Sub(queuedAt) <= w.config.DedupWindow {
+		return true
+	}
+	if len(w.queued) >= DedupPruneSize {
+		for queuedKey, queuedAt := range w.queued {
+			if now.Sub(queuedAt) > w.config.DedupWindow {
+				delete(w.queued, queuedKey)
+			}
+		}
+	}
+	w.queued[key] = now
+	return false
+}
+
+// dequeued forgets the queued event once the worker processes it, the next identical event is a new change
+func (w *WatchService) dequeued(evt *fsnotify.FileEvent) {
+	w.queuedLock.Lock()
+	defer w.queuedLock.Unlock()
+	delete(w.queued, getEventType(evt)+" "+evt.Name)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestIsQueuedDuplicate(t *testing.T) {
+	w := &WatchService{config: &Config{Dedup: true, DedupWindow: time.Second}, queued: make(map[string]time.Time)}
+	evt := &fsnotify.FileEvent{Name: "main.go"}
+	now := time.Now()
+
+	if w.isQueuedDuplicate(evt, now) {
+		t.Fatal("the first event is not a duplicate")
+	}
+	if !w.isQueuedDuplicate(evt, now.Add(time.Millisecond)) {
+		t.Fatal("an identical queued event should make it a duplicate")
+	}
+	if w.isQueuedDuplicate(&fsnotify.FileEvent{Name: "other.go"}, now) {
+		t.Fatal("an event of another path is not a duplicate")
+	}
+
+	w.dequeued(evt)
+	if w.isQueuedDuplicate(evt, now.Add(2*time.Millisecond)) {
+		t.Fatal("the event was processed, the next one is a new change")
+	}
+	if w.isQueuedDuplicate(evt, now.Add(2*time.Second)) {
+		t.Fatal("the queued event is older than the window")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// DiffMaxFileSize is the size above which the content of a file is not kept for -diff-summary
+	DiffMaxFileSize = 1024 * 1024
+	// DiffBinaryCheckSize is how many leading bytes are checked for a NUL byte, which marks a binary file
+	DiffBinaryCheckSize = 8000
+)
+
+// diffContent counts the lines added to and removed from the file of the trigger since its previous content, kept in
+// its cached entry. A new file only adds lines, the first change of a file seen before only keeps its content. Large
+// and binary files are skipped and their content is dropped.
+func (w *WatchService) diffContent(trigger *Trigger) {
+	path := trigger.Event.Name
+	entry := w.entries[path]
+	if entry == nil {
+		var err error
+		if entry, err = newFileEntry(path, w.hash); err != nil {
+			Logln(err)
+			return
+		}
+		w.entries[path] = entry
+	}
+
+	content, err := readTextFile(path)
+	if err != nil {
+		Logln(err)
+	}
+	if content == nil {
+		entry.content = nil
+		return
+	}
+	if entry.content != nil || trigger.Event.IsCreate() {
+		trigger.LinesAdded, trigger.LinesRemoved = diffLines(entry.content, content)
+		trigger.Diffed = true
+		Logf("%s: %s +%d -%d lines", trigger.eventType(), path, trigger.LinesAdded, trigger.LinesRemoved)
+	}
+	entry.content = content
+}
+
+// readTextFile returns the content of the file, or nil when it is larger than DiffMaxFileSize or binary
+func readTextFile(path string) (content []byte, err error) {
+	st, err := os.Stat(path)
+	if err != nil || !st.Mode().IsRegular() || st.Size() > DiffMaxFileSize {
+		return
+	}
+	if content, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	head := content
+	if len(head) > DiffBinaryCheckSize {
+		head = head[:DiffBinaryCheckSize]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+	return
+}
+
+// diffLines counts the lines of the new content missing from the old one and the other way around, the lines are
+// compared as a multiset: a moved line is neither added nor removed
+func diffLines(old, new []byte) (added, removed int) {
+	counts := make(map[string]int)
+	for _, line := range splitLines(old) {
+		counts[line]++
+	}
+	for _, line := range splitLines(new) {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			added++
+		}
+	}
+	for _, count := range counts {
+		removed += count
+	}
+	return
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = string(bytes.TrimSuffix(line, []byte("\r")))
+	}
+	return result
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		old, new       string
+		added, removed int
+	}{
+		{"", "a\nb\n", 2, 0},
+		{"a\nb\nc\n", "a\nc\n", 0, 1},
+		{"a\nb\n", "a\nB\nc\n", 2, 1},
+		{"a\nb\n", "b\r\na\r\n", 0, 0},
+		{"a\na\n", "a\n", 0, 1},
+	}
+	for _, test := range tests {
+		added, removed := diffLines([]byte(test.old), []byte(test.new))
+		if added != test.added || removed != test.removed {
+			t.Errorf("diffLines(%q, %q) = +%d -%d, expected +%d -%d", test.old, test.new, added, removed, test.added, test.removed)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+// CountEventType is the event type (%t) of a directory whose entry count reached the threshold
+const CountEventType = "ENTRY_COUNT"
+
+// countDirEntries counts the files of the directory matching the pattern of the rule, relative to the watch root
+func (w *WatchService) countDirEntries(dir string, rule *Rule) (count int, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if !info.IsDir() && checkPatternMatching(rule.pattern, w.relativeToRoot(filepath.Join(dir, info.Name()))) {
+			count++
+		}
+	}
+	return
+}
+
+// handleDirCount runs the commands of the rule once the number of matching files of the directory of the event
+// crosses the threshold, %f is the directory
+func (w *WatchService) handleDirCount(evt *fsnotify.FileEvent, rule *Rule) (runID string) {
+	dir := filepath.Dir(filepath.Clean(evt.Name))
+	count, err := w.countDirEntries(dir, rule)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	previous, found := w.dirCounts[dir]
+	if !found {
+		// the count before this event
+		previous = count - 1
+		if !evt.IsCreate() {
+			previous = count + 1
+		}
+	}
+	w.dirCounts[dir] = count
+	Logf("directory %s, matching entries: %d (was %d), threshold: %d", dir, count, previous, w.config.DirCountThreshold)
+
+	threshold := w.config.DirCountThreshold
+	if previous >= threshold || count < threshold {
+		return
+	}
+	if !checkStartupGrace(w.startedAt, w.config.StartupGrace, time.Now()) || w.Paused() || !checkSchedule(w.schedule, time.Now()) {
+		Logf("%s: %s suppressed", CountEventType, dir)
+		return
+	}
+
+	log.Printf("directory %s has %d matching entries", dir, count)
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: dir}, Dir: true, Rule: rule, Count: count}
+	return w.execute(trigger)
+}
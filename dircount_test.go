@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestHandleDirCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.IncludePattern = "\\.csv$"
+	config.Commands = StringSet{"true"}
+	config.DirCountThreshold = 3
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string) *fsnotify.FileEvent {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return &fsnotify.FileEvent{Name: path}
+	}
+	expectCommands := func(expected uint64, reason string) {
+		if commands := w.Stats().Commands; commands != expected {
+			t.Fatalf("%s: expected %d commands, got %d", reason, expected, commands)
+		}
+	}
+
+	write("a.csv")
+	write("notes.txt")
+	w.handleDirCount(write("b.csv"), w.rules[0])
+	expectCommands(0, "2 matching files are below the threshold")
+	w.handleDirCount(write("c.csv"), w.rules[0])
+	expectCommands(1, "3 matching files reach the threshold")
+	w.handleDirCount(write("d.csv"), w.rules[0])
+	expectCommands(1, "the threshold was already reached")
+
+	for _, name := range []string{"c.csv", "d.csv"} {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		w.handleDirCount(&fsnotify.FileEvent{Name: path}, w.rules[0])
+	}
+	expectCommands(1, "the count dropped below the threshold")
+	w.handleDirCount(write("e.csv"), w.rules[0])
+	expectCommands(2, "the count crossed the threshold again")
+}
+
+func TestCountDirEntriesRelativeToRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	incoming := filepath.Join(dir, "incoming")
+	if err := os.Mkdir(incoming, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.csv", "b.csv", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(incoming, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		count   int
+	}{
+		{"^incoming/[^/]*\\.csv$", 2},
+		{"^incoming/", 3},
+		{"\\.txt$", 1},
+		{"^" + dir, 0},
+	}
+	for _, test := range tests {
+		config := *defaultConfig
+		config.NoSummary = true
+		config.IncludePattern = test.pattern
+		config.DirCountThreshold = 2
+		w, err := NewWatchService(dir, &config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := w.countDirEntries(incoming, w.rules[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != test.count {
+			t.Errorf("%s: expected %d matching files, got %d", test.pattern, test.count, count)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+// DryPassReport is the result of a dry pass: the number of existing files, how many files each rule matches
+// (indexed like the rules) and how many files each filter skipped
+type DryPassReport struct {
+	Files   int
+	Matches []int
+	Skipped map[string]int
+}
+
+// runDryPass validates the configuration like a watch, then runs the filters and the rule patterns against the
+// existing files and prints how many files each rule would run its commands for, without running them. The files
+// are matched by path only, a rule runs for the event types it lists.
+func runDryPass(config *Config) (err error) {
+	dry := *config
+	dry.PublishTo = ""
+	w, err := NewTargetService(&dry)
+	if err != nil {
+		return
+	}
+	report, err := w.dryPass(time.Now())
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("%d files\n", report.Files)
+	for _, rule := range w.rules {
+		events := make([]string, 0, len(rule.watchFlags))
+		for name := range rule.watchFlags {
+			events = append(events, name)
+		}
+		sort.Strings(events)
+		fmt.Printf("rule %d, pattern \"%s\" on %s: %d files\n", rule.index, rule.pattern, strings.Join(events, ","), report.Matches[rule.index])
+		for _, command := range rule.commands {
+			fmt.Println("  " + command)
+		}
+	}
+	reasons := make([]string, 0, len(report.Skipped))
+	for reason := range report.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("%s: %d files\n", reason, report.Skipped[reason])
+	}
+	return
+}
+
+// dryPass lists the existing files of the watched tree and applies the filters and the rule patterns to them
+func (w *WatchService) dryPass(now time.Time) (report *DryPassReport, err error) {
+	if w.config.FromFile != "" {
+		if w.manifest, err = LoadManifest(w.config.FromFile); err != nil {
+			return
+		}
+	}
+
+	var paths []string
+	list := func(dir string) error {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if info.Mode().IsRegular() {
+				paths = append(paths, filepath.Join(dir, info.Name()))
+			}
+		}
+		return nil
+	}
+	if w.config.Recursive {
+		for _, root := range w.treeRoots() {
+			if err = w.walkTree(root, list, func(path string, reason string, _ error) {
+				fmt.Printf("%s skipped, %s\n", path, reason)
+			}); err != nil {
+				return
+			}
+		}
+	} else if err = list(w.path); err != nil {
+		return
+	}
+
+	report = &DryPassReport{Files: len(paths), Matches: make([]int, len(w.rules)), Skipped: make(map[string]int)}
+	for _, path := range paths {
+		reason := w.dryRunSkipReason(path, now)
+		if reason == "" {
+			if rules := w.matchPatterns(path); len(rules) > 0 {
+				for _, rule := range rules {
+					report.Matches[rule.index]++
+					Logf("%s: rule %d", path, rule.index)
+				}
+				continue
+			}
+			reason = "no matching rule"
+		}
+		report.Skipped[reason]++
+		Logf("%s: %s", path, reason)
+	}
+	return
+}
+
+// dryRunSkipReason applies the filters of handleEvent to an existing file, an empty reason means the file passes
+func (w *WatchService) dryRunSkipReason(path string, now time.Time) string {
+	switch {
+	case w.manifest != nil && !checkManifest(w.manifest, &fsnotify.FileEvent{Name: path}):
+		return "not listed in " + w.config.FromFile
+	case checkIgnored(w.ignore, w.relativeToRoot(path), false):
+		return "ignored by " + IgnoreFile
+	case w.filters != nil && !checkFilterRules(w.filters, w.relativeToRoot(path), false):
+		return "excluded by " + w.config.FilterFile
+	case w.inSyncDestination(path) || w.isStateFile(path) || w.isReadyFile(path) || w.isConfigFile(path):
+		return "written by " + Program
+	case len(w.config.ExcludeExts) > 0 && checkExcludedExt(w.config.ExcludeExts, path):
+		return "excluded extension"
+	case (w.config.MinAge > 0 || w.config.MaxAge > 0) && !checkFileAge(path, w.config.MinAge, w.config.MaxAge, now):
+		return "out of the age range"
+	}
+	if st, err := os.Stat(path); err == nil && isSpecialFile(st) {
+		return "not a regular file"
+	}
+	return ""
+}
+
+// matchPatterns returns the rules whose pattern matches the path, whatever their event types
+func (w *WatchService) matchPatterns(path string) (rules []*Rule) {
+	for _, rule := range w.rules {
+		if checkPatternMatching(rule.pattern, w.relativeToRoot(path)) {
+			rules = append(rules, rule)
+		}
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDryPass(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"main.go", "util.go", "README.md", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := filepath.Join(dir, "manifest")
+	content := filepath.Join(dir, "main.go") + "\n" + filepath.Join(dir, "README.md") + "\n" + filepath.Join(dir, "notes.txt") + "\n"
+	if err := ioutil.WriteFile(manifest, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.FromFile = manifest
+	config.Rules = []RuleConfig{
+		{Pattern: "\\.go$", Commands: StringSet{"go test"}},
+		{Pattern: "\\.md$", Commands: StringSet{"make docs"}},
+	}
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := w.dryPass(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Files != 5 {
+		t.Errorf("expected 5 files, got %d", report.Files)
+	}
+	if expected := []int{1, 1}; !reflect.DeepEqual(report.Matches, expected) {
+		t.Errorf("expected the matches %v, got %v", expected, report.Matches)
+	}
+	expected := map[string]int{"not listed in " + manifest: 2, "no matching rule": 1}
+	if !reflect.DeepEqual(report.Skipped, expected) {
+		t.Errorf("expected the skipped files %v, got %v", expected, report.Skipped)
+	}
+}
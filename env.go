@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadEnvFile reads key=value pairs from an env file, blank lines and lines starting with # are ignored
+func LoadEnvFile(filename string) (env []string, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		idx := strings.Index(line, "=")
+		if idx <= 0 {
+			err = fmt.Errorf("%s:%d: invalid line %q", filename, lineNo, line)
+			return
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := parseEnvValue(strings.TrimSpace(line[idx+1:]))
+		env = append(env, key+"="+value)
+	}
+	err = scanner.Err()
+	return
+}
+
+func parseEnvValue(value string) string {
+	if len(value) >= 2 {
+		quote := value[0]
+		if (quote == '"' || quote == '\'') && value[len(value)-1] == quote {
+			value = value[1 : len(value)-1]
+			if quote == '"' {
+				value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value)
+			}
+			return value
+		}
+	}
+
+	// strip trailing comments from unquoted values
+	if idx := strings.Index(value, " #"); idx >= 0 {
+		value = strings.TrimSpace(value[:idx])
+	}
+	return value
+}
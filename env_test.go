@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"plain # comment", "plain"},
+		{`"quoted # not a comment"`, "quoted # not a comment"},
+		{`"line\nbreak \"quote\""`, "line\nbreak \"quote\""},
+		{`'single \n'`, `single \n`},
+		{`"unterminated`, `"unterminated`},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if actual := parseEnvValue(test.value); actual != test.expected {
+			t.Errorf("parseEnvValue(%q) = %q, expected %q", test.value, actual, test.expected)
+		}
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# database\n\nDB_HOST=localhost\nexport DB_NAME = \"dev db\"\nEMPTY=\n")
+	f.Close()
+
+	env, err := LoadEnvFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"DB_HOST=localhost", "DB_NAME=dev db", "EMPTY="}; !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %q, got %q", expected, env)
+	}
+
+	ioutil.WriteFile(f.Name(), []byte("DB_HOST=localhost\nnot a pair\n"), 0644)
+	if _, err := LoadEnvFile(f.Name()); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("expected the invalid line 2 to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of configuration errors, use errors.Is to tell them apart
+var (
+	// ErrNoEvents is returned when no event is watched
+	ErrNoEvents = errors.New("zero events is simply not enough")
+	// ErrInvalidEvent is matched by the errors of the unknown event names
+	ErrInvalidEvent = errors.New("invalid event")
+	// ErrBadPattern is matched by the errors of the patterns that are not valid regular expressions
+	ErrBadPattern = errors.New("bad pattern")
+	// ErrMissingCommand is matched by the errors of the commands whose program cannot be found
+	ErrMissingCommand = errors.New("missing command")
+	// ErrInvalidOption is matched by the errors of the unknown option values (e.g. -on-overflow or -log-format)
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// EventError reports an unknown event name
+type EventError struct {
+	Event string
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("the event %s was not found", e.Event)
+}
+
+// Is matches ErrInvalidEvent
+func (e *EventError) Is(target error) bool {
+	return target == ErrInvalidEvent
+}
+
+// PatternError reports a pattern that is not a valid regular expression
+type PatternError struct {
+	Pattern string
+	Err     error
+}
+
+func (e *PatternError) Error() string {
+	return e.Err.Error()
+}
+
+// Is matches ErrBadPattern
+func (e *PatternError) Is(target error) bool {
+	return target == ErrBadPattern
+}
+
+// Unwrap returns the error of the regexp package
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+// OptionError reports an unknown value of an option
+type OptionError struct {
+	Option string
+	Value  string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("the %s %s was not found", e.Option, e.Value)
+}
+
+// Is matches ErrInvalidOption
+func (e *OptionError) Is(target error) bool {
+	return target == ErrInvalidOption
+}
+
+// CommandError reports a command whose program cannot be found
+type CommandError struct {
+	Command string
+	Program string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("the program %s of the command \"%s\" was not found (start with -allow-missing to run it anyway)", e.Program, e.Command)
+}
+
+// Is matches ErrMissingCommand
+func (e *CommandError) Is(target error) bool {
+	return target == ErrMissingCommand
+}
+
+// Unwrap returns the error of exec.LookPath
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
@@ -1,58 +1,629 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"code.google.com/p/go.exp/fsnotify"
 	"github.com/mgutz/ansi"
 )
 
 const (
+	// ReadyFileCheckInterval is how often the ready file is checked while waiting for it
+	ReadyFileCheckInterval = time.Duration(50) * time.Millisecond
+
 	// VarFilename is used for printing file names
 	VarFilename = "%f"
 	// VarEventType is used for printing event types
 	VarEventType = "%t"
+	// VarAttrib is used for printing the attribute changes of attrib events
+	VarAttrib = "%attr"
+	// VarSnapshot is used for printing the path of the snapshot of the changed file (with -snapshot)
+	VarSnapshot = "%s"
+	// VarLine is used for printing the matching line (with -grep)
+	VarLine = "%line"
+	// VarFiles is used for printing the files of the run, shell-quoted and space-joined (use with a shell prefix)
+	VarFiles = "%F"
+	// VarFileList is used for printing the path of a file listing the files of the run, NUL-terminated for xargs -0
+	VarFileList = "%L"
+	// VarLinesAdded and VarLinesRemoved are used for printing the line counts of a modified file (with -diff-summary)
+	VarLinesAdded   = "%added"
+	VarLinesRemoved = "%removed"
+	// VarFailedCommand is used for printing the failed command in the -on-failure commands
+	VarFailedCommand = "%cmd"
+	// VarExitCode is used for printing the exit code of the failed command in the -on-failure commands
+	VarExitCode = "%code"
 )
 
 // Executor struct models the command(s) to be executed by our watcher
 type Executor struct {
 	Stdout io.Writer
 	Stderr io.Writer
+	Env    []string
+
+	// OutputTemplate redirects the output of each command to a file named after the event (e.g. logs/%f.%t.log)
+	OutputTemplate string
+
+	// AtomicOutput writes the stdout of a command to a temporary file renamed to the OutputTemplate file when the
+	// command succeeds, a failed command leaves the previous output intact
+	AtomicOutput bool
+
+	// CombineOutput writes the stderr of the commands to their stdout
+	CombineOutput bool
+
+	// Remote runs the commands on a remote host instead of locally when set
+	Remote *RemoteExecutor
+
+	// Timeout kills a local command running longer, Timeouts overrides it for some commands, 0 means no limit
+	Timeout  time.Duration
+	Timeouts map[string]time.Duration
+
+	// Allowed lists the base names of the programs the commands may run, any program may run when it is empty
+	Allowed []string
+
+	// MemLimit is the address space limit of the local commands (linux only), 0 means no limit
+	MemLimit ByteSize
+
+	// ReadyFile completes a run when the command creates it instead of when the command exits, for the commands
+	// starting background work, ReadyTimeout fails the run when the file was not created in time
+	ReadyFile    string
+	ReadyTimeout time.Duration
+
+	// Nice is the niceness of the local commands (a priority class on windows), 0 leaves the priority unchanged
+	Nice int
+
+	// Pty runs the local commands in a pseudo-terminal relayed to Stdout (not supported on windows)
+	Pty bool
+
+	// FileMode is the permissions of the output files
+	FileMode os.FileMode
+
+	// EventNames maps the event types (e.g. ENTRY_CREATE) to the names %t expands to, the unmapped types are kept
+	EventNames map[string]string
 }
 
-func (e *Executor) execute(command string, evt *fsnotify.FileEvent) error {
-	command = evaluateVariables(command, evt)
-	commandArgs := strings.Split(command, " ")
+// Trigger describes the event a run of the commands is handling
+type Trigger struct {
+	RunID string
+	Event *fsnotify.FileEvent
+	Stdin []byte
+	Dir   bool
+	Rule  *Rule
 
-	var cmd *exec.Cmd
-	if len(commandArgs) > 1 {
-		cmd = exec.Command(commandArgs[0], commandArgs[1:]...)
-	} else {
-		cmd = exec.Command(commandArgs[0])
+	// Attrib describes the metadata changes of an attrib event, AttribChanges lists them
+	Attrib        string
+	AttribChanges []AttribChange
+
+	// Symlink is the new target of a retargeted symlink
+	Symlink string
+
+	// Count is the number of matching entries of a directory that reached the -dir-count threshold
+	Count int
+
+	// Snapshot is the path of a copy of the changed file taken before the commands ran
+	Snapshot string
+
+	// Line is the appended line matching -grep, the last one unless -grep-each runs the commands per line, Grepped
+	// tells it is set, the matching line may be empty
+	Line    string
+	Grepped bool
+
+	// Files are the files of the events collapsed into this run (with -latest-wins), the event file otherwise
+	Files []string
+
+	// FileList is the temporary file listing the Files for %L
+	FileList string
+
+	// LinesAdded and LinesRemoved count the changed lines of a small text file when Diffed (with -diff-summary)
+	Diffed       bool
+	LinesAdded   int
+	LinesRemoved int
+
+	// FailedCommand and ExitCode describe the failed command an -on-failure command is run for
+	FailedCommand string
+	ExitCode      int
+
+	// Manual indicates the run was requested by the run signal, it also runs the tripped commands
+	Manual bool
+}
+
+// env returns the environment variables describing the trigger to its commands
+func (trigger *Trigger) env() (env []string) {
+	if trigger.Grepped {
+		env = append(env, "WATCHF_LINE="+trigger.Line)
+	}
+	if trigger.Diffed {
+		env = append(env, "WATCHF_LINES_ADDED="+strconv.Itoa(trigger.LinesAdded), "WATCHF_LINES_REMOVED="+strconv.Itoa(trigger.LinesRemoved))
+	}
+	for _, change := range trigger.AttribChanges {
+		if change != AttribNew {
+			name := strings.ToUpper(change.Name)
+			env = append(env, "WATCHF_OLD_"+name+"="+change.Old, "WATCHF_NEW_"+name+"="+change.New)
+		}
+	}
+	if trigger.FailedCommand != "" {
+		env = append(env, "WATCHF_FAILED_COMMAND="+trigger.FailedCommand, "WATCHF_EXIT_CODE="+strconv.Itoa(trigger.ExitCode))
+	}
+	return
+}
+
+// forRule returns a copy of the trigger running the commands of the rule
+func (trigger *Trigger) forRule(rule *Rule) *Trigger {
+	ruleTrigger := *trigger
+	ruleTrigger.Rule = rule
+	return &ruleTrigger
+}
+
+// files returns the files of the run, the event file when no events were collapsed into it
+func (trigger *Trigger) files() []string {
+	if len(trigger.Files) > 0 {
+		return trigger.Files
+	}
+	return []string{trigger.Event.Name}
+}
+
+// eventType returns the event type of the trigger, retargeted symlinks and manual runs have no fsnotify event type
+func (trigger *Trigger) eventType() string {
+	if trigger.Manual {
+		return ManualEventType
+	}
+	if trigger.Symlink != "" {
+		return RetargetEventType
+	}
+	if trigger.Count > 0 {
+		return CountEventType
+	}
+	return getEventType(trigger.Event)
+}
+
+func (e *Executor) execute(command string, trigger *Trigger) (err error) {
+	evt := trigger.Event
+	timeout := e.timeoutFor(command)
+	if strings.Contains(command, VarFileList) || strings.Contains(e.OutputTemplate, VarFileList) {
+		listTrigger := *trigger
+		if listTrigger.FileList, err = writeFileList(trigger.files()); err != nil {
+			return
+		}
+		defer os.Remove(listTrigger.FileList)
+		trigger = &listTrigger
+	}
+	command = e.evaluateVariables(command, trigger)
+	prefix := "[" + trigger.RunID + "] "
+
+	// a denied command does not touch its output file
+	if program, allowed := e.allowedProgram(command); !allowed {
+		msg := fmt.Sprintf("%sexec: \"%s\" denied, %s is not an allowed command", prefix, command, program)
+		log.Println(ansi.Color(msg, "red+b"))
+		return fmt.Errorf("the command %s is not allowed", program)
+	}
+
+	var stdin io.Reader
+	if trigger.Stdin != nil {
+		stdin = bytes.NewReader(trigger.Stdin)
+	}
+
+	var stdout, stderr io.Writer
+	stdout = &PrefixWriter{Writer: e.Stdout, Prefix: prefix}
+	stderr = &PrefixWriter{Writer: e.Stderr, Prefix: prefix}
+	if e.OutputTemplate != "" {
+		outputPath := e.evaluateVariables(e.OutputTemplate, trigger)
+		var output *os.File
+		var errOutput error
+		if e.AtomicOutput {
+			output, errOutput = createTempOutputFile(outputPath, e.FileMode)
+		} else {
+			output, errOutput = createOutputFile(outputPath, e.FileMode)
+		}
+		if errOutput != nil {
+			msg := fmt.Sprintf("%sexec: \"%s\" cannot create output file, err: %s", prefix, command, errOutput)
+			log.Println(ansi.Color(msg, "red+b"))
+			return errOutput
+		}
+
+		if e.AtomicOutput {
+			// only stdout is the output, stderr stays in the log
+			defer func() {
+				if errCommit := commitOutputFile(output, outputPath, err); errCommit != nil {
+					log.Println(ansi.Color(fmt.Sprintf("%scannot write output file, err: %s", prefix, errCommit), "red+b"))
+				}
+			}()
+			stdout = output
+		} else {
+			defer output.Close()
+			stdout, stderr = output, output
+		}
+	}
+
+	if e.CombineOutput {
+		// the same writer keeps the order of the lines written to stdout and stderr
+		stderr = stdout
 	}
-	cmd.Stderr = e.Stderr
-	cmd.Stdout = e.Stdout
 
-	msg := fmt.Sprintf("exec: \"%s %s\"", cmd.Args[0], strings.Join(cmd.Args[1:], " "))
 	log.Println(ansi.Color("", "cyan+b"))
-	log.Println(ansi.Color(evt.String(), "cyan+b"))
-	log.Println(ansi.Color(msg, "cyan+b"))
-	err := cmd.Run()
+	log.Println(ansi.Color(prefix+evt.String(), "cyan+b"))
+
+	env := append(append([]string{}, e.Env...), trigger.env()...)
+	var description string
+	if e.Remote != nil {
+		command = remoteCommand(command)
+		description = fmt.Sprintf("%s on %s", command, e.Remote.Host)
+		log.Println(ansi.Color(fmt.Sprintf("%sexec: \"%s\"", prefix, description), "cyan+b"))
+		err = e.Remote.Run(command, env, stdin, stdout, stderr)
+	} else {
+		ctx := context.Background()
+		if timeout > 0 && e.ReadyFile == "" {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		commandArgs := parseCommand(command)
+		cmd := exec.CommandContext(ctx, commandArgs[0], commandArgs[1:]...)
+		cmd.Stdin = stdin
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		var releasePty func()
+		if e.Pty {
+			if releasePty, err = attachPty(cmd, stdout); err != nil {
+				log.Printf("%scannot open a pseudo-terminal, run with pipes: %s", prefix, err)
+				cmd.Stdout, cmd.Stderr = stdout, stderr
+				err = nil
+			}
+		}
+
+		description = strings.Join(cmd.Args, " ")
+		log.Println(ansi.Color(fmt.Sprintf("%sexec: \"%s\"", prefix, description), "cyan+b"))
+		if e.ReadyFile != "" {
+			// a ready file left by the previous run must not complete this one
+			os.Remove(e.ReadyFile)
+		}
+		if err = startWithNice(cmd, e.Nice); err == nil {
+			if e.MemLimit > 0 {
+				if errLimit := limitMemory(cmd.Process.Pid, int64(e.MemLimit)); errLimit != nil {
+					log.Printf("%scannot limit the memory of %s: %s", prefix, cmd.Path, errLimit)
+				}
+			}
+			if e.ReadyFile != "" {
+				err = e.waitReady(cmd)
+			} else {
+				err = cmd.Wait()
+			}
+		}
+		if releasePty != nil {
+			releasePty()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("killed after the timeout of %s", timeout)
+		}
+	}
 
 	if err != nil {
-		msg := fmt.Sprintf("exec: \"%s %s\" failed, err: %s", cmd.Args[0], strings.Join(cmd.Args[1:], " "), err)
+		msg := fmt.Sprintf("%sexec: \"%s\" failed, err: %s", prefix, description, err)
 		log.Println(ansi.Color(msg, "red+b"))
 	}
 
-	return err
+	return
+}
+
+// exitCodeOf returns the exit status of the failed command, -1 when it did not exit with a status (e.g. it was
+// denied or killed)
+func exitCodeOf(err error) int {
+	var exitStatus interface{ ExitStatus() int }
+	if errors.As(err, &exitStatus) {
+		return exitStatus.ExitStatus()
+	}
+	var exitCode interface{ ExitCode() int }
+	if errors.As(err, &exitCode) {
+		return exitCode.ExitCode()
+	}
+	return -1
+}
+
+// waitReady waits for the command to create the ready file, the command may keep running in the background. A
+// command still running when the -ready-timeout passes is killed
+func (e *Executor) waitReady(cmd *exec.Cmd) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(ReadyFileCheckInterval)
+	defer ticker.Stop()
+	deadline := time.After(e.ReadyTimeout)
+	for {
+		select {
+		case err := <-exited:
+			if err != nil {
+				return err
+			}
+			// the command forked its background work, keep waiting for the file
+			exited = nil
+		case <-ticker.C:
+			if _, err := os.Stat(e.ReadyFile); err == nil {
+				return nil
+			}
+		case <-deadline:
+			// a command that is still running is killed, so that each timed out run does not leak a process
+			if exited != nil {
+				cmd.Process.Kill()
+				<-exited
+			}
+			return fmt.Errorf("the ready file %s was not created within %s", e.ReadyFile, e.ReadyTimeout)
+		}
+	}
 }
 
-func evaluateVariables(command string, evt *fsnotify.FileEvent) string {
+// allowedProgram returns the base name of the program run by the command (the interpreter of a prefixed command) and
+// whether it is allowed
+func (e *Executor) allowedProgram(command string) (program string, allowed bool) {
+	args := parseCommand(command)
+	if len(args) == 0 {
+		return "", len(e.Allowed) == 0
+	}
+	program = strings.TrimSuffix(filepath.Base(args[0]), ".exe")
+	if len(e.Allowed) == 0 {
+		return program, true
+	}
+	for _, name := range e.Allowed {
+		if name == program {
+			return program, true
+		}
+	}
+	return program, false
+}
+
+// lookupPrograms checks the programs of the commands can be found, the commands with an interpreter prefix and
+// the programs named by a variable are skipped
+func lookupPrograms(commands []string) error {
+	for _, command := range commands {
+		if idx := strings.Index(command, ":"); idx > 0 {
+			if _, found := Interpreters[command[:idx]]; found {
+				continue
+			}
+		}
+		program := parseCommand(command)[0]
+		if program == "" || strings.Contains(program, "%") {
+			continue
+		}
+		if _, err := exec.LookPath(program); err != nil {
+			return &CommandError{command, program, err}
+		}
+	}
+	return nil
+}
+
+// timeoutFor returns the timeout of the command, a command without its own timeout (or 0) uses the global one
+func (e *Executor) timeoutFor(command string) time.Duration {
+	if timeout := e.Timeouts[command]; timeout > 0 {
+		return timeout
+	}
+	return e.Timeout
+}
+
+// Interpreters maps the command prefixes (e.g. "sh:") to the arguments that run the rest of the command as a script
+var Interpreters = map[string][]string{
+	"sh":         {"sh", "-c"},
+	"bash":       {"bash", "-c"},
+	"zsh":        {"zsh", "-c"},
+	"python":     {"python", "-c"},
+	"python3":    {"python3", "-c"},
+	"node":       {"node", "-e"},
+	"ruby":       {"ruby", "-e"},
+	"perl":       {"perl", "-e"},
+	"cmd":        {"cmd", "/C"},
+	"powershell": {"powershell", "-Command"},
+}
+
+// parseCommand splits a command into program and arguments, commands with an interpreter prefix are passed to it unsplit
+// and structured commands are used as they are
+func parseCommand(command string) []string {
+	if args, structured := parseStructuredCommand(command); structured {
+		return args
+	}
+	if idx := strings.Index(command, ":"); idx > 0 {
+		if interpreter, found := Interpreters[command[:idx]]; found {
+			args := append([]string{}, interpreter...)
+			return append(args, strings.TrimSpace(command[idx+1:]))
+		}
+	}
+	return strings.Split(command, " ")
+}
+
+// parseStructuredCommand decodes a command given as a JSON array of the program and its arguments, e.g.
+// ["grep", "-r", "TODO list", "%f"]
+func parseStructuredCommand(command string) (args []string, structured bool) {
+	if !strings.HasPrefix(strings.TrimSpace(command), "[") {
+		return
+	}
+	if err := json.Unmarshal([]byte(command), &args); err != nil || len(args) == 0 {
+		return nil, false
+	}
+	return args, true
+}
+
+// remoteCommand quotes the script of a command with an interpreter prefix, or each argument of the other commands,
+// for the remote shell. A plain command is split like a local one and never interpreted by the remote shell, so that
+// a file name cannot inject commands.
+func remoteCommand(command string) string {
+	if idx := strings.Index(command, ":"); idx > 0 {
+		if interpreter, found := Interpreters[command[:idx]]; found {
+			return strings.Join(interpreter, " ") + " " + shellQuote(strings.TrimSpace(command[idx+1:]))
+		}
+	}
+	args := parseCommand(command)
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// eventName returns the name of the event type of the trigger for %t
+func (e *Executor) eventName(trigger *Trigger) string {
+	eventType := trigger.eventType()
+	if name, found := e.EventNames[eventType]; found {
+		return name
+	}
+	return eventType
+}
+
+// evaluateVariables replaces the variables of the command, in each argument of a structured command so that the
+// values never change how it is split
+func (e *Executor) evaluateVariables(command string, trigger *Trigger) string {
+	args, structured := parseStructuredCommand(command)
+	if !structured {
+		return e.expandVariables(command, trigger)
+	}
+	for i, arg := range args {
+		args[i] = e.expandVariables(arg, trigger)
+	}
+	encoded, _ := json.Marshal(args)
+	return string(encoded)
+}
+
+func (e *Executor) expandVariables(command string, trigger *Trigger) string {
+	evt := trigger.Event
+	command = strings.Replace(command, VarAttrib, trigger.Attrib, -1)
+	if trigger.Snapshot != "" {
+		command = strings.Replace(command, VarSnapshot, trigger.Snapshot, -1)
+	}
 	command = strings.Replace(command, VarFilename, evt.Name, -1)
-	command = strings.Replace(command, VarEventType, getEventType(evt), -1)
+	command = strings.Replace(command, VarEventType, e.eventName(trigger), -1)
+	if trigger.Grepped {
+		command = strings.Replace(command, VarLine, trigger.Line, -1)
+	}
+	if strings.Contains(command, VarFiles) {
+		quoted := make([]string, 0, len(trigger.files()))
+		for _, file := range trigger.files() {
+			quoted = append(quoted, shellQuote(file))
+		}
+		command = strings.Replace(command, VarFiles, strings.Join(quoted, " "), -1)
+	}
+	if trigger.FileList != "" {
+		command = strings.Replace(command, VarFileList, trigger.FileList, -1)
+	}
+	if trigger.Diffed {
+		command = strings.Replace(command, VarLinesAdded, strconv.Itoa(trigger.LinesAdded), -1)
+		command = strings.Replace(command, VarLinesRemoved, strconv.Itoa(trigger.LinesRemoved), -1)
+	}
+	if trigger.FailedCommand != "" {
+		command = strings.Replace(command, VarExitCode, strconv.Itoa(trigger.ExitCode), -1)
+		// last, so that the variables of the failed command are not evaluated again
+		command = strings.Replace(command, VarFailedCommand, trigger.FailedCommand, -1)
+	}
 	return command
 }
+
+// writeFileList writes the files to a temporary file, each one terminated by a NUL byte so that paths with spaces
+// or newlines are read back intact by xargs -0
+func writeFileList(files []string) (path string, err error) {
+	f, err := ioutil.TempFile("", Program+"-files")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	for _, file := range files {
+		if _, err = f.WriteString(file + "\x00"); err != nil {
+			os.Remove(f.Name())
+			return
+		}
+	}
+	path = f.Name()
+	return
+}
+
+// createOutputFile creates the file and its parent directories, a counter is appended to the name if it already exists
+func createOutputFile(path string, mode os.FileMode) (f *os.File, err error) {
+	path = filepath.Clean(path)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	name := path
+	for i := 1; ; i++ {
+		f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err == nil {
+			// the umask may have removed some of the permissions
+			err = f.Chmod(mode)
+		}
+		if !os.IsExist(err) {
+			return
+		}
+		name = fmt.Sprintf("%s.%d", path, i)
+	}
+}
+
+// createTempOutputFile creates a temporary file next to the output file, so that it can be renamed into place
+func createTempOutputFile(path string, mode os.FileMode) (f *os.File, err error) {
+	path = filepath.Clean(path)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	if f, err = ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"."); err != nil {
+		return
+	}
+	// the output file keeps the permissions of the temporary file once renamed
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		f = nil
+	}
+	return
+}
+
+// commitOutputFile renames the temporary file to the output file when the command succeeded, otherwise removes it
+func commitOutputFile(f *os.File, path string, errCommand error) (err error) {
+	err = f.Close()
+	if errCommand != nil || err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err = os.Rename(f.Name(), filepath.Clean(path)); err != nil {
+		os.Remove(f.Name())
+	}
+	return
+}
+
+// snapshotFile copies the file to a temporary file outside of the watched directories, keeping its extension
+func snapshotFile(path string) (snapshot string, err error) {
+	if st, errStat := os.Stat(path); errStat == nil && isSpecialFile(st) {
+		err = fmt.Errorf("cannot snapshot %s, it is not a regular file", path)
+		return
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile("", Program+"-*"+filepath.Ext(path))
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(out.Name())
+		return
+	}
+	snapshot = out.Name()
+	return
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"go test", []string{"go", "test"}},
+		{"make", []string{"make"}},
+		{"sh:go vet && go test | tee out.log", []string{"sh", "-c", "go vet && go test | tee out.log"}},
+		{"bash: echo $HOME", []string{"bash", "-c", "echo $HOME"}},
+		{"python:print('changed')", []string{"python", "-c", "print('changed')"}},
+		{"cmd:dir /b", []string{"cmd", "/C", "dir /b"}},
+		{"unknown:foo bar", []string{"unknown:foo", "bar"}},
+		{"C:\\tools\\build.exe -v", []string{"C:\\tools\\build.exe", "-v"}},
+		{`["grep", "-r", "TODO list", "src"]`, []string{"grep", "-r", "TODO list", "src"}},
+		{"[ -f go.mod ]", []string{"[", "-f", "go.mod", "]"}},
+	}
+
+	for _, test := range tests {
+		actual := parseCommand(test.command)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("parseCommand(%q) = %q, expected %q", test.command, actual, test.expected)
+		}
+	}
+}
+
+func TestAllowedProgram(t *testing.T) {
+	executor := &Executor{Allowed: []string{"go", "sh"}}
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"go test", true},
+		{"/usr/local/go/bin/go vet", true},
+		{"sh:go vet && go test", true},
+		{"rm -rf build", false},
+		{"bash:echo changed", false},
+	}
+
+	for _, test := range tests {
+		if _, allowed := executor.allowedProgram(test.command); allowed != test.allowed {
+			t.Errorf("allowedProgram(%q) = %v, expected %v", test.command, allowed, test.allowed)
+		}
+	}
+
+	if _, allowed := (&Executor{}).allowedProgram("rm -rf build"); !allowed {
+		t.Error("an empty list should allow every command")
+	}
+}
+
+func TestDeniedCommandOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, atomic := range []bool{false, true} {
+		e := &Executor{Allowed: []string{"go"}, OutputTemplate: filepath.Join(dir, "%f.log"), AtomicOutput: atomic,
+			FileMode: 0644, Stdout: os.Stdout, Stderr: os.Stderr}
+		if err := e.execute("rm -rf build", &Trigger{Event: &fsnotify.FileEvent{Name: "main.go"}}); err == nil {
+			t.Fatal("the command should be denied")
+		}
+		if infos, _ := ioutil.ReadDir(dir); len(infos) != 0 {
+			t.Errorf("atomic %t: a denied command should not create its output file, got %s", atomic, infos[0].Name())
+		}
+	}
+}
+
+func TestLookupPrograms(t *testing.T) {
+	if err := lookupPrograms([]string{"sh:watchf-no-such-program", "%f --check"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := lookupPrograms([]string{"watchf-no-such-program -v"}); !errors.Is(err, ErrMissingCommand) {
+		t.Errorf("expected an error matching %q, got %v", ErrMissingCommand, err)
+	}
+}
+
+func TestEvaluateVariablesFailure(t *testing.T) {
+	e := &Executor{}
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: "main.go"}}
+	if actual := e.evaluateVariables("notify %cmd %code", trigger); actual != "notify %cmd %code" {
+		t.Errorf("the failure variables should be kept outside of the -on-failure commands, got %q", actual)
+	}
+
+	trigger.FailedCommand, trigger.ExitCode = "grep %f", 2
+	if actual, expected := e.evaluateVariables("notify %f %cmd %code", trigger), "notify main.go grep %f 2"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if env := trigger.env(); !reflect.DeepEqual(env, []string{"WATCHF_FAILED_COMMAND=grep %f", "WATCHF_EXIT_CODE=2"}) {
+		t.Errorf("unexpected environment %q", env)
+	}
+}
+
+func TestEventNames(t *testing.T) {
+	e := &Executor{EventNames: map[string]string{"ENTRY_COUNT": "count"}}
+	if actual, expected := e.evaluateVariables("echo %t", &Trigger{Event: &fsnotify.FileEvent{Name: "src"}, Count: 3}), "echo count"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if actual, expected := e.evaluateVariables("echo %t", &Trigger{Event: &fsnotify.FileEvent{Name: "link"}, Symlink: "target"}), "echo ENTRY_RETARGET"; actual != expected {
+		t.Errorf("the unmapped event types should be kept, expected %q, got %q", expected, actual)
+	}
+}
+
+func TestEvaluateVariablesFiles(t *testing.T) {
+	e := &Executor{}
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: "my docs/a.txt"}, Files: []string{"my docs/a.txt", "it's.txt"}}
+	if actual, expected := e.evaluateVariables("sh:wc %F", trigger), `sh:wc 'my docs/a.txt' 'it'\''s.txt'`; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	trigger.Files = nil
+	if actual, expected := e.evaluateVariables("sh:wc %F", trigger), `sh:wc 'my docs/a.txt'`; actual != expected {
+		t.Errorf("expected the event file alone, got %q", actual)
+	}
+}
+
+func TestWriteFileList(t *testing.T) {
+	files := []string{"my docs/a.txt", "line\nbreak.txt"}
+	path, err := writeFileList(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.Split(strings.TrimSuffix(string(content), "\x00"), "\x00"); !reflect.DeepEqual(actual, files) {
+		t.Errorf("expected %q, got %q", files, actual)
+	}
+}
+
+func TestEvaluateVariablesStructured(t *testing.T) {
+	e := &Executor{}
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: `my "docs"\a b.txt`}}
+	command := e.evaluateVariables(`["wc", "-l", "%f"]`, trigger)
+	if args, expected := parseCommand(command), []string{"wc", "-l", `my "docs"\a b.txt`}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+	if actual, expected := remoteCommand(command), `'wc' '-l' 'my "docs"\a b.txt'`; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestEvaluateAttribNew(t *testing.T) {
+	e := &Executor{}
+	changes := []AttribChange{AttribNew}
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: "main.go"}, Attrib: formatAttribChanges(changes), AttribChanges: changes}
+	if actual := e.evaluateVariables("echo %attr", trigger); actual != "echo new" {
+		t.Errorf("expected %q, got %q", "echo new", actual)
+	}
+	if env := trigger.env(); len(env) != 0 {
+		t.Errorf("a new file has no old attributes, got %q", env)
+	}
+}
+
+func TestRemoteCommandQuoting(t *testing.T) {
+	e := &Executor{}
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: "x;rm$(id)`w`'.txt"}}
+	command := remoteCommand(e.evaluateVariables("wc -l %f", trigger))
+	if expected := `'wc' '-l' 'x;rm$(id)` + "`w`" + `'\''.txt'`; command != expected {
+		t.Errorf("expected %q, got %q", expected, command)
+	}
+	if actual, expected := remoteCommand("sh:wc -l *.go | sort"), `sh -c 'wc -l *.go | sort'`; actual != expected {
+		t.Errorf("the script of an interpreter prefix should run in the remote shell, expected %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,57 @@
+// +build freebsd openbsd netbsd darwin linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestWaitReadyKillsOnTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := &Executor{ReadyFile: filepath.Join(dir, "ready"), ReadyTimeout: 100 * time.Millisecond}
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.waitReady(cmd); err == nil {
+		t.Fatal("expected the ready timeout")
+	}
+	if cmd.ProcessState == nil || cmd.ProcessState.Success() {
+		t.Errorf("the command should be killed on the timeout, got %v", cmd.ProcessState)
+	}
+}
+
+func TestExecuteEnvFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("GREETING=\"hello world\"\n")
+	f.Close()
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.EnvFile = f.Name()
+	w, err := NewWatchService(".", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: "main.go"}}
+	if err := w.executor.execute(`sh:test "$GREETING" = "hello world"`, trigger); err != nil {
+		t.Errorf("the commands should see the variables of the env file, got %v", err)
+	}
+}
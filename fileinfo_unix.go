@@ -0,0 +1,23 @@
+// +build freebsd openbsd netbsd darwin linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileOwner(info os.FileInfo) (uid int, gid int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
+
+// fileInode returns the inode number of the file, 0 when unknown
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
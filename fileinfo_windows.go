@@ -0,0 +1,16 @@
+// +build windows
+
+package main
+
+import (
+	"os"
+)
+
+func fileOwner(info os.FileInfo) (uid int, gid int) {
+	return -1, -1
+}
+
+// fileInode returns 0, os.FileInfo carries no file index on windows
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,37 @@
+// +build freebsd openbsd netbsd darwin linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+const fileLockSupported = true
+
+// waitForFileUnlocked waits until an exclusive lock can be taken on the file, i.e. the writer released its lock
+func waitForFileUnlocked(path string) (err error) {
+	Logf("wait for the file %s lock", path)
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	deadline := time.Now().Add(FileLockWaitTimeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		}
+		if err != syscall.EWOULDBLOCK {
+			return
+		}
+		if time.Now().After(deadline) {
+			Logf("file %s is still locked after %s", path, FileLockWaitTimeout)
+			return nil
+		}
+		time.Sleep(FileCloseCheckInterval)
+	}
+}
@@ -0,0 +1,9 @@
+// +build windows
+
+package main
+
+const fileLockSupported = false
+
+func waitForFileUnlocked(path string) error {
+	return waitForFileClose(path)
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"bufio"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"hash/adler32"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,16 +20,36 @@ import (
 )
 
 const (
+	// CloseSizeStable considers a file closed when its size stops changing
+	CloseSizeStable = "size-stable"
+	// CloseNone does not wait for the file to be closed
+	CloseNone = "none"
+	// CloseFlock considers a file closed when an exclusive lock can be taken on it (unix only)
+	CloseFlock = "flock"
+
+	// FileLockWaitTimeout is how long the flock strategy waits for the lock before hashing the file anyway
+	FileLockWaitTimeout = time.Duration(10) * time.Second
 	// FileCloseCheckInterval is the sleep interval used while checking if a file is officially closed.
 	FileCloseCheckInterval = time.Duration(20) * time.Millisecond
 	// FileCloseCheckThreshold indicates the number of times we check a file when considering a file officially closed?
 	FileCloseCheckThreshold = 2
 )
 
+// caseInsensitiveFS indicates the default filesystems of the platform ignore the case of file names
+var caseInsensitiveFS = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
 // FileEntry is used to track which files have been watched.
 type FileEntry struct {
-	size int64
-	hash uint32
+	size   int64
+	hash   string
+	offset int64
+	mode   os.FileMode
+	uid    int
+	gid    int
+	inode  uint64
+
+	// content is the previous content of a small text file, kept for -diff-summary
+	content []byte
 }
 
 func checkEventType(watchedEvents map[string]EventBit, evt *fsnotify.FileEvent) bool {
@@ -39,17 +65,19 @@ func checkEventType(watchedEvents map[string]EventBit, evt *fsnotify.FileEvent)
 		matched := false
 		switch {
 		case evt.IsCreate():
-			Logf("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "create")
+			Tracef("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "create")
 			_, matched = watchedEvents[CreateEvent.Name]
 		case evt.IsAttrib():
+			Tracef("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "attrib")
+			_, matched = watchedEvents[AttribEvent.Name]
 		case evt.IsModify():
-			Logf("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "modify | attrib")
+			Tracef("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "modify | attrib")
 			_, matched = watchedEvents[ModifyEvent.Name]
 		case evt.IsDelete():
-			Logf("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "delete")
+			Tracef("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "delete")
 			_, matched = watchedEvents[DeleteEvent.Name]
 		case evt.IsRename():
-			Logf("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "rename")
+			Tracef("Does watched events of '%s' contain the '%s' fsnotify event?", joinedWatchedEvents, "rename")
 			_, matched = watchedEvents[RenameEvent.Name]
 		}
 
@@ -57,19 +85,77 @@ func checkEventType(watchedEvents map[string]EventBit, evt *fsnotify.FileEvent)
 	})
 }
 
-func checkPatternMatching(pattern *regexp.Regexp, evt *fsnotify.FileEvent) bool {
+// eventBitOf returns the watchable event corresponding to the fsnotify event
+func eventBitOf(evt *fsnotify.FileEvent) (eventBit EventBit, ok bool) {
+	switch {
+	case evt.IsCreate():
+		return CreateEvent, true
+	case evt.IsAttrib():
+		return AttribEvent, true
+	case evt.IsModify():
+		return ModifyEvent, true
+	case evt.IsDelete():
+		return DeleteEvent, true
+	case evt.IsRename():
+		return RenameEvent, true
+	}
+	return
+}
+
+// checkPatternMatching matches the path relative to the watch root, e.g. src/main.go, whatever the watch mode
+func checkPatternMatching(pattern *regexp.Regexp, path string) bool {
 	return decorator("check filename is matching the pattern", func() bool {
-		Logf("%s ~= %s", pattern, evt.Name)
-		matched := pattern.MatchString(evt.Name)
+		Tracef("%s ~= %s", pattern, path)
+		matched := pattern.MatchString(path)
 		return matched
 	})
 }
 
+func checkManifest(manifest map[string]bool, evt *fsnotify.FileEvent) bool {
+	return decorator("check filename is listed in the manifest", func() bool {
+		path := filepath.Clean(evt.Name)
+		// the entries of a listed directory are listed too
+		return manifest[path] || manifest[filepath.Dir(path)]
+	})
+}
+
+func checkIgnored(matcher *IgnoreMatcher, path string, isDir bool) bool {
+	return decorator("check filename is ignored by "+IgnoreFile, func() bool {
+		return matcher.Match(path, isDir)
+	})
+}
+
+// checkExcludedExt reports whether the extension of the file is excluded, extensions are compared case-insensitively on
+// the platforms whose filesystems are case-insensitive by default
+func checkExcludedExt(exts []string, path string) bool {
+	return decorator("check file extension is excluded", func() bool {
+		ext := filepath.Ext(path)
+		for _, excluded := range exts {
+			if !strings.HasPrefix(excluded, ".") {
+				excluded = "." + excluded
+			}
+			if ext == excluded || (caseInsensitiveFS && strings.EqualFold(ext, excluded)) {
+				Tracef("%s has the excluded extension %s", path, excluded)
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func checkFilterRules(filters *FilterRules, path string, isDir bool) bool {
+	return decorator("check filename is included by the filter rules", func() bool {
+		return filters.Included(path, isDir)
+	})
+}
+
 func decorator(title string, fun func() bool) bool {
 	startTime := time.Now()
-	Logln("[" + title + "]")
+	Traceln("[" + title + "]")
 	result := fun()
-	Logf("[pass: %v, time: %s]", result, time.Since(startTime))
+	elapsed := time.Since(startTime)
+	Tracef("[pass: %v, time: %s]", result, elapsed)
+	filterTimings.Add(title, elapsed)
 
 	return result
 }
@@ -81,25 +167,96 @@ func checkExecInterval(lastExec time.Time, interval time.Duration, now time.Time
 		}
 		nextExec := lastExec.Add(interval)
 		delta := now.Sub(nextExec)
-		Logf("next execution time: %s, now: %s, delta:%s", nextExec, now, delta)
+		Tracef("next execution time: %s, now: %s, delta:%s", nextExec, now, delta)
 		return delta > 0
 	})
 }
 
-func checkFileContentChanged(entries map[string]*FileEntry, path string) bool {
+func checkEventCount(count int, threshold int) bool {
+	return decorator("check event count reached the threshold", func() bool {
+		Tracef("event count: %d, threshold: %d", count, threshold)
+		return count >= threshold
+	})
+}
+
+func checkSchedule(schedule []ScheduleWindow, now time.Time) bool {
+	return decorator("check the local time is inside the active hours", func() bool {
+		if len(schedule) == 0 {
+			return true
+		}
+		Tracef("now: %s", now.Format("15:04:05 MST"))
+		for _, window := range schedule {
+			if window.Contains(now) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func checkStartupGrace(startedAt time.Time, grace time.Duration, now time.Time) bool {
+	return decorator("check startup grace period is over", func() bool {
+		if grace == 0 {
+			return true
+		}
+		Tracef("started at: %s, grace: %s, now: %s", startedAt, grace, now)
+		return now.Sub(startedAt) >= grace
+	})
+}
+
+// checkFollowsCreate reports whether the modify of a file follows its create within the window, the create already
+// ran the commands for the new file
+func checkFollowsCreate(created map[string]time.Time, path string, window time.Duration, now time.Time) bool {
+	return decorator("check the modify follows the create of the file", func() bool {
+		createdAt, found := created[path]
+		if !found {
+			return false
+		}
+		delete(created, path)
+		Tracef("file %s, created at: %s, window: %s, now: %s", path, createdAt, window, now)
+		return now.Sub(createdAt) <= window
+	})
+}
+
+// checkFileAge indicates the file was last modified at least minAge and at most maxAge ago (0 disables a bound), a
+// file that cannot be stat-ed passes
+func checkFileAge(path string, minAge, maxAge time.Duration, now time.Time) bool {
+	return decorator("check the file modification age", func() bool {
+		st, err := os.Stat(path)
+		if err != nil {
+			return true
+		}
+		age := now.Sub(st.ModTime())
+		Tracef("file %s, modified: %s, age: %s", path, st.ModTime(), age)
+		return (minAge == 0 || age >= minAge) && (maxAge == 0 || age <= maxAge)
+	})
+}
+
+// checkFileContentChanged compares the file against its cached entry. When waitClose is nil the file is hashed
+// right away, which is fine for editors that write atomically but may hash a half-written file otherwise. A nil
+// hasher uses adler32.
+func checkFileContentChanged(entries map[string]*FileEntry, path string, waitClose func(path string) error, hash Hasher) bool {
 	return decorator("check the file content is changed", func() bool {
+		// reading a named pipe or a device could block the worker, their events are changes
+		if st, err := os.Stat(path); err == nil && isSpecialFile(st) {
+			Tracef("file %s is not a regular file (%s), its content is not read", path, st.Mode())
+			return true
+		}
+
 		contentChanged := false
 		// THINK: handle continues event from writing a big file
-		err := waitForFileClose(path)
-		if err != nil {
-			log.Println(err)
-			return false
+		if waitClose != nil {
+			err := waitClose(path)
+			if err != nil {
+				log.Println(err)
+				return false
+			}
 		}
 
 		cachedEntry, found := entries[path]
 		if !found {
 			// THINK: preload all file entries
-			newEntry, err := newFileEntry(path)
+			newEntry, err := newFileEntry(path, hash)
 			if err != nil {
 				log.Println(err)
 				return false
@@ -108,24 +265,35 @@ func checkFileContentChanged(entries map[string]*FileEntry, path string) bool {
 			contentChanged = true
 		} else {
 
-			contentSize, err := getFileSize(path)
+			st, err := os.Stat(path)
 			if err != nil {
 				log.Println(err)
 				return false
 			}
-			Logf("file %s, size: %d", path, contentSize)
+			contentSize := st.Size()
+			Tracef("file %s, size: %d", path, contentSize)
+
+			// editors saving through a temporary file replace the inode, even if the content looks the same
+			if inode := fileInode(st); inode != 0 && cachedEntry.inode != 0 && cachedEntry.inode != inode {
+				Tracef("file %s, inode: %d > %d", path, cachedEntry.inode, inode)
+				contentChanged = true
+			}
+			cachedEntry.inode = fileInode(st)
 
 			if cachedEntry.size != contentSize {
 				cachedEntry.size = contentSize
 				contentChanged = true
 			}
 
-			contentHash, err := getContentHash(path)
+			if hash == nil {
+				hash = getContentHash
+			}
+			contentHash, err := hash(path)
 			if err != nil {
 				log.Println(err)
 				return false
 			}
-			Logf("file %s, hash: %d", path, contentHash)
+			Tracef("file %s, hash: %s", path, contentHash)
 
 			if cachedEntry.hash != contentHash {
 				cachedEntry.hash = contentHash
@@ -137,6 +305,122 @@ func checkFileContentChanged(entries map[string]*FileEntry, path string) bool {
 	})
 }
 
+// readAppendedContent returns the content written after the entry's offset, a truncated file is read from the start
+func readAppendedContent(entry *FileEntry, path string) (appended []byte, err error) {
+	if entry.size < entry.offset {
+		Logf("file %s, truncated", path)
+		entry.offset = 0
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	appended = make([]byte, entry.size-entry.offset)
+	n, err := f.ReadAt(appended, entry.offset)
+	if err == io.EOF {
+		err = nil
+	}
+	appended = appended[:n]
+	entry.offset += int64(n)
+	return
+}
+
+// AttribChange is a metadata change of a file, e.g. {"mode", "-rw-r--r--", "-rw-------"}
+type AttribChange struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// AttribNew is the change of a file whose attributes were not cached yet, %attr is "new" and there is no old value
+// to compare against
+var AttribNew = AttribChange{Name: "new"}
+
+func (c AttribChange) String() string {
+	if c == AttribNew {
+		return c.Name
+	}
+	return c.Name + ":" + c.Old + ">" + c.New
+}
+
+// formatAttribChanges describes the changes as %attr, e.g. "mode:-rw-r--r-->-rw-------,uid:0>1000"
+func formatAttribChanges(changes []AttribChange) string {
+	descs := make([]string, len(changes))
+	for i, change := range changes {
+		descs[i] = change.String()
+	}
+	return strings.Join(descs, ",")
+}
+
+// checkAttributesChanged compares the file metadata against its cached entry and returns the changes, nothing
+// changed when it is empty
+func checkAttributesChanged(entries map[string]*FileEntry, path string, hash Hasher) (changes []AttribChange) {
+	decorator("check the file attributes are changed", func() bool {
+		st, err := os.Stat(path)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		uid, gid := fileOwner(st)
+
+		cachedEntry, found := entries[path]
+		if !found {
+			// THINK: preload all file entries
+			newEntry, err := newFileEntry(path, hash)
+			if err != nil {
+				log.Println(err)
+				return false
+			}
+			entries[path] = newEntry
+			changes = []AttribChange{AttribNew}
+			return true
+		}
+
+		if cachedEntry.mode != st.Mode() {
+			changes = append(changes, AttribChange{"mode", cachedEntry.mode.String(), st.Mode().String()})
+			cachedEntry.mode = st.Mode()
+		}
+		if cachedEntry.uid != uid {
+			changes = append(changes, AttribChange{"uid", strconv.Itoa(cachedEntry.uid), strconv.Itoa(uid)})
+			cachedEntry.uid = uid
+		}
+		if cachedEntry.gid != gid {
+			changes = append(changes, AttribChange{"gid", strconv.Itoa(cachedEntry.gid), strconv.Itoa(gid)})
+			cachedEntry.gid = gid
+		}
+		if cachedEntry.size != st.Size() {
+			changes = append(changes, AttribChange{"size", strconv.FormatInt(cachedEntry.size, 10), strconv.FormatInt(st.Size(), 10)})
+			cachedEntry.size = st.Size()
+		}
+		Tracef("file %s, attributes changes: %v", path, changes)
+
+		return len(changes) > 0
+	})
+	return
+}
+
+// closeWaiter returns the function waiting for a file to be closed with the given strategy
+func closeWaiter(strategy string) (waitClose func(path string) error, err error) {
+	switch strategy {
+	case CloseSizeStable:
+		waitClose = waitForFileClose
+	case CloseNone:
+	case CloseFlock:
+		if fileLockSupported {
+			waitClose = waitForFileUnlocked
+		} else {
+			log.Printf("the close strategy %s is not supported on this platform, using %s", CloseFlock, CloseSizeStable)
+			waitClose = waitForFileClose
+		}
+	default:
+		err = &OptionError{"close strategy", strategy}
+	}
+	return
+}
+
 func waitForFileClose(path string) (err error) {
 	Logf("wait for the file %s close", path)
 	var lastSize int64
@@ -162,18 +446,25 @@ func waitForFileClose(path string) (err error) {
 	}
 }
 
-func newFileEntry(filename string) (entry *FileEntry, err error) {
-	contentSize, err := getFileSize(filename)
+// newFileEntry caches the metadata and the hash of the file, a nil hasher uses adler32
+func newFileEntry(filename string, hash Hasher) (entry *FileEntry, err error) {
+	st, err := os.Stat(filename)
 	if err != nil {
 		return
 	}
 
-	sum, err := getContentHash(filename)
-	if err != nil {
-		return
+	if hash == nil {
+		hash = getContentHash
+	}
+	var sum string
+	if !isSpecialFile(st) {
+		if sum, err = hash(filename); err != nil {
+			return
+		}
 	}
 
-	entry = &FileEntry{contentSize, sum}
+	uid, gid := fileOwner(st)
+	entry = &FileEntry{size: st.Size(), hash: sum, offset: st.Size(), mode: st.Mode(), uid: uid, gid: gid, inode: fileInode(st)}
 	return
 }
 
@@ -186,14 +477,27 @@ func getFileSize(filename string) (size int64, err error) {
 	return
 }
 
-func getContentHash(filename string) (sum uint32, err error) {
+// isSpecialFile indicates the file is a named pipe, a socket or a device, whose content must not be read
+func isSpecialFile(st os.FileInfo) bool {
+	return st.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice|os.ModeCharDevice) != 0
+}
+
+func getContentHash(filename string) (sum string, err error) {
+	return hashContent(filename, adler32.New())
+}
+
+// hashContent returns the sum of the content of the file
+func hashContent(filename string, writer hash.Hash) (sum string, err error) {
+	if st, errStat := os.Stat(filename); errStat == nil && isSpecialFile(st) {
+		err = fmt.Errorf("cannot hash %s, it is not a regular file", filename)
+		return
+	}
 	f, err := os.Open(filename)
 	defer f.Close()
 	if err != nil {
 		return
 	}
 
-	writer := adler32.New()
 	reader := bufio.NewReader(f)
 
 	_, err = io.Copy(writer, reader)
@@ -201,6 +505,6 @@ func getContentHash(filename string) (sum uint32, err error) {
 		return
 	}
 
-	sum = writer.Sum32()
+	sum = hex.EncodeToString(writer.Sum(nil))
 	return
 }
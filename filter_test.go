@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCheckFileContentChangedWaitClose(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("content")
+	f.Close()
+
+	minWait := FileCloseCheckInterval * FileCloseCheckThreshold
+
+	startTime := time.Now()
+	if !checkFileContentChanged(make(map[string]*FileEntry), f.Name(), waitForFileClose, nil) {
+		t.Fatal("wait: new file should be reported as changed")
+	}
+	if elapsed := time.Since(startTime); elapsed < minWait {
+		t.Fatalf("wait: expected to wait at least %s, got %s", minWait, elapsed)
+	}
+
+	startTime = time.Now()
+	if !checkFileContentChanged(make(map[string]*FileEntry), f.Name(), nil, nil) {
+		t.Fatal("no wait: new file should be reported as changed")
+	}
+	if elapsed := time.Since(startTime); elapsed >= minWait {
+		t.Fatalf("no wait: expected the wait to be skipped, took %s", elapsed)
+	}
+}
+
+func TestCloseWaiter(t *testing.T) {
+	if waitClose, err := closeWaiter(CloseNone); err != nil || waitClose != nil {
+		t.Errorf("%s should not wait, got %v", CloseNone, err)
+	}
+	for _, strategy := range []string{CloseSizeStable, CloseFlock} {
+		if waitClose, err := closeWaiter(strategy); err != nil || waitClose == nil {
+			t.Errorf("%s should wait, falling back to %s where unsupported, got %v", strategy, CloseSizeStable, err)
+		}
+	}
+	if _, err := closeWaiter("inotify"); err == nil {
+		t.Error("an unknown strategy should be rejected")
+	}
+}
+
+func TestCheckFileContentChangedInode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no inode numbers on windows")
+	}
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "main.go")
+	ioutil.WriteFile(path, []byte("content"), 0644)
+	entries := make(map[string]*FileEntry)
+	checkFileContentChanged(entries, path, nil, nil)
+
+	if checkFileContentChanged(entries, path, nil, nil) {
+		t.Fatal("unchanged file should not be reported as changed")
+	}
+
+	// save the same content through a temporary file, as some editors do
+	tmp := filepath.Join(dir, ".main.go.tmp")
+	ioutil.WriteFile(tmp, []byte("content"), 0644)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	if !checkFileContentChanged(entries, path, nil, nil) {
+		t.Fatal("replaced file should be reported as changed")
+	}
+}
+
+func TestCheckFileAge(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	now := time.Now()
+	modTime := now.Add(-time.Hour)
+	os.Chtimes(f.Name(), modTime, modTime)
+
+	tests := []struct {
+		minAge   time.Duration
+		maxAge   time.Duration
+		expected bool
+	}{
+		{0, 0, true},
+		{0, 2 * time.Hour, true},
+		{0, time.Minute, false},
+		{time.Minute, 0, true},
+		{2 * time.Hour, 0, false},
+		{time.Minute, 2 * time.Hour, true},
+	}
+	for _, test := range tests {
+		if actual := checkFileAge(f.Name(), test.minAge, test.maxAge, now); actual != test.expected {
+			t.Errorf("checkFileAge(min %s, max %s) = %v, expected %v", test.minAge, test.maxAge, actual, test.expected)
+		}
+	}
+
+	if !checkFileAge(f.Name()+".missing", 0, time.Minute, now) {
+		t.Error("a missing file should pass")
+	}
+}
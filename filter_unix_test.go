@@ -0,0 +1,105 @@
+// +build freebsd openbsd netbsd darwin linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCheckFileContentChangedFifo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pipe")
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		t.Skip("cannot create a fifo:", err)
+	}
+
+	entries := make(map[string]*FileEntry)
+	changed := make(chan bool, 2)
+	go func() {
+		changed <- checkFileContentChanged(entries, path, nil, nil)
+		changed <- checkFileContentChanged(entries, path, nil, nil)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case actual := <-changed:
+			if !actual {
+				t.Error("fifo events should be reported as changed")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the fifo content was read")
+		}
+	}
+	if _, err := newFileEntry(path, nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestWaitForFileUnlocked(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	hold := 100 * time.Millisecond
+	fd := int(f.Fd())
+	released := make(chan bool)
+	go func() {
+		time.Sleep(hold)
+		syscall.Flock(fd, syscall.LOCK_UN)
+		close(released)
+	}()
+	startTime := time.Now()
+	err = waitForFileUnlocked(f.Name())
+	<-released
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(startTime); elapsed < hold {
+		t.Errorf("expected to wait for the writer to release its lock, returned after %s", elapsed)
+	}
+}
+
+func TestCheckAttributesChanged(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	entries := make(map[string]*FileEntry)
+	if changes := checkAttributesChanged(entries, f.Name(), nil); !reflect.DeepEqual(changes, []AttribChange{AttribNew}) {
+		t.Errorf("expected the file to be new, got %v", changes)
+	}
+	if err := os.Chmod(f.Name(), 0640); err != nil {
+		t.Fatal(err)
+	}
+	changes := checkAttributesChanged(entries, f.Name(), nil)
+	if len(changes) != 1 || changes[0].Name != "mode" || changes[0].New != os.FileMode(0640).String() {
+		t.Errorf("expected the mode change, got %v", changes)
+	}
+	if desc := formatAttribChanges(changes); desc != "mode:"+changes[0].Old+">-rw-r-----" {
+		t.Errorf("unexpected description %q", desc)
+	}
+	trigger := &Trigger{AttribChanges: changes}
+	if env := trigger.env(); !reflect.DeepEqual(env, []string{"WATCHF_OLD_MODE=" + changes[0].Old, "WATCHF_NEW_MODE=-rw-r-----"}) {
+		t.Errorf("unexpected environment %q", env)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// FilterInclude acts upon the paths matching no filter rule
+	FilterInclude = "include"
+	// FilterExclude skips the paths matching no filter rule
+	FilterExclude = "exclude"
+)
+
+type filterRule struct {
+	include  bool
+	pattern  *regexp.Regexp
+	dirOnly  bool
+	anchored bool
+}
+
+// FilterRules decides whether a path is acted upon with ordered include/exclude rules (like rsync filter rules), the
+// first matching rule wins
+type FilterRules struct {
+	rules          []filterRule
+	defaultInclude bool
+}
+
+// NewFilterRules creates FilterRules without rules, defaultAction is FilterInclude or FilterExclude
+func NewFilterRules(defaultAction string) (filters *FilterRules, err error) {
+	switch defaultAction {
+	case FilterInclude, FilterExclude:
+	default:
+		err = &OptionError{"filter default", defaultAction}
+		return
+	}
+	filters = &FilterRules{defaultInclude: defaultAction == FilterInclude}
+	return
+}
+
+// LoadFilterRules reads the rules of a filter file, one "include <glob>" or "exclude <glob>" per line
+func LoadFilterRules(filename string, defaultAction string) (filters *FilterRules, err error) {
+	if filters, err = NewFilterRules(defaultAction); err != nil {
+		return
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if errRule := filters.AddRule(scanner.Text()); errRule != nil {
+			err = fmt.Errorf("%s:%d: %s", filename, lineNo, errRule)
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// AddRule parses a rule, blank lines and comments are skipped. The glob uses the syntax of the ignore file: a glob
+// containing a slash is matched against the path relative to the watch root, otherwise against the name, and a
+// trailing slash only matches directories
+func (f *FilterRules) AddRule(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid rule %q, expected \"include <glob>\" or \"exclude <glob>\"", line)
+	}
+
+	rule := filterRule{}
+	switch fields[0] {
+	case FilterInclude, "+":
+		rule.include = true
+	case FilterExclude, "-":
+	default:
+		return fmt.Errorf("invalid action %q, expected include or exclude", fields[0])
+	}
+
+	glob := fields[1]
+	if strings.HasSuffix(glob, "/") {
+		rule.dirOnly = true
+		glob = strings.TrimRight(glob, "/")
+	}
+	if strings.Contains(glob, "/") {
+		rule.anchored = true
+		glob = strings.TrimPrefix(glob, "/")
+	}
+	if glob == "" {
+		return fmt.Errorf("invalid rule %q, the glob is empty", line)
+	}
+
+	pattern, err := regexp.Compile("^" + globToRegexp(glob) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid glob %q: %s", glob, err)
+	}
+	rule.pattern = pattern
+	f.rules = append(f.rules, rule)
+	return nil
+}
+
+// Match returns the action of the first rule matching the path (relative to the watch root), matched is false when
+// no rule matches
+func (f *FilterRules) Match(path string, isDir bool) (include bool, matched bool) {
+	path = filepath.ToSlash(filepath.Clean(path))
+	name := path[strings.LastIndex(path, "/")+1:]
+	for _, rule := range f.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := name
+		if rule.anchored {
+			target = path
+		}
+		if rule.pattern.MatchString(target) {
+			return rule.include, true
+		}
+	}
+	return
+}
+
+// Included reports whether the path is acted upon, the default applies when no rule matches
+func (f *FilterRules) Included(path string, isDir bool) bool {
+	if f == nil {
+		return true
+	}
+	include, matched := f.Match(path, isDir)
+	if !matched {
+		return f.defaultInclude
+	}
+	return include
+}
+
+// Excluded reports whether a directory is explicitly excluded, the default does not apply so that directories are
+// still walked when the default is exclude
+func (f *FilterRules) Excluded(path string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	include, matched := f.Match(path, isDir)
+	return matched && !include
+}
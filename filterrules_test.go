@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFilterRulesOrdering(t *testing.T) {
+	filters, err := NewFilterRules(FilterExclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# teams",
+		"",
+		"exclude services/legacy/**",
+		"include services/**/*.go",
+		"exclude *_test.go",
+		"include *.go",
+		"+ docs/*.md",
+		"exclude tmp/",
+	} {
+		if err := filters.AddRule(line); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"main.go", false, true},
+		{"main_test.go", false, false},
+		{"pkg/util.go", false, true},
+		{"services/api/handler.go", false, true},
+		// the earlier include wins over the later exclude
+		{"services/api/handler_test.go", false, true},
+		// the earlier exclude wins over the later include
+		{"services/legacy/old.go", false, false},
+		{"docs/README.md", false, true},
+		{"README.md", false, false},
+		{"tmp", true, false},
+		{"tmp", false, false},
+	}
+
+	for _, test := range tests {
+		if actual := filters.Included(test.path, test.isDir); actual != test.expected {
+			t.Errorf("Included(%q, %v) = %v, expected %v", test.path, test.isDir, actual, test.expected)
+		}
+	}
+
+	if filters.Excluded("pkg", true) {
+		t.Error("the default should not exclude directories")
+	}
+	if !filters.Excluded("tmp", true) {
+		t.Error("tmp/ should be excluded")
+	}
+}
+
+func TestFilterRulesDefault(t *testing.T) {
+	filters, err := NewFilterRules(FilterInclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters.AddRule("exclude *.tmp")
+	if !filters.Included("main.go", false) || filters.Included("main.tmp", false) {
+		t.Error("unexpected result with the include default")
+	}
+
+	if _, err := NewFilterRules("maybe"); err == nil {
+		t.Error("maybe: expected an error")
+	}
+	for _, line := range []string{"include", "keep *.go", "include a b"} {
+		if err := filters.AddRule(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestLoadFilterRulesInvalidGlob(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("include *.go\nexclude [z-a]\n")
+	f.Close()
+
+	if _, err := LoadFilterRules(f.Name(), FilterInclude); err == nil || !strings.Contains(err.Error(), f.Name()+":2:") {
+		t.Errorf("expected an error naming the file and the line, got %v", err)
+	}
+}
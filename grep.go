@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// grepLines returns the lines of the content matching the pattern, without their line endings
+func grepLines(pattern *regexp.Regexp, content []byte) (matches [][]byte) {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if pattern.Match(line) {
+			matches = append(matches, line)
+		}
+	}
+	return
+}
+
+// grepAppended returns the appended lines matching -grep, one per line, or nil when none matches. The end of a line
+// still being written is kept until the next event completes it, the last matching line is set on the trigger
+func (w *WatchService) grepAppended(trigger *Trigger, appended []byte) []byte {
+	content := append(w.grepPartial, appended...)
+	end := bytes.LastIndexByte(content, '\n')
+	if end < 0 {
+		w.grepPartial = content
+		return nil
+	}
+	w.grepPartial = append([]byte(nil), content[end+1:]...)
+
+	matches := grepLines(w.grep, content[:end])
+	if len(matches) == 0 {
+		return nil
+	}
+	trigger.Line, trigger.Grepped = string(matches[len(matches)-1]), true
+	return append(bytes.Join(matches, []byte("\n")), '\n')
+}
+
+// executeLines runs the commands once per matching line of the trigger (with -grep-each), the line is piped to their
+// stdin and set as %line
+func (w *WatchService) executeLines(trigger *Trigger) (runID string) {
+	for _, line := range bytes.Split(bytes.TrimSuffix(trigger.Stdin, []byte("\n")), []byte("\n")) {
+		lineTrigger := *trigger
+		lineTrigger.Line = string(line)
+		lineTrigger.Stdin = append(line, '\n')
+		runID = w.execute(&lineTrigger)
+	}
+	return
+}
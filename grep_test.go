@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestGrepAppended(t *testing.T) {
+	w := &WatchService{grep: regexp.MustCompile("ERROR")}
+
+	trigger := &Trigger{}
+	if matches := w.grepAppended(trigger, []byte("INFO start\nERROR one\nERR")); string(matches) != "ERROR one\n" {
+		t.Errorf("expected the complete matching line, got %q", matches)
+	}
+	if trigger.Line != "ERROR one" {
+		t.Errorf("expected the last matching line, got %q", trigger.Line)
+	}
+	if matches := w.grepAppended(trigger, []byte("OR two")); matches != nil {
+		t.Errorf("expected the unterminated line to be kept, got %q", matches)
+	}
+	if matches := w.grepAppended(trigger, []byte("\r\nINFO end\nERROR three\n")); string(matches) != "ERROR two\nERROR three\n" {
+		t.Errorf("expected the completed line and the new one, got %q", matches)
+	}
+	if matches := w.grepAppended(trigger, []byte("INFO\n")); matches != nil {
+		t.Errorf("expected no match, got %q", matches)
+	}
+}
+
+func TestCollapseGrepped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Commands = StringSet{"true"}
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.GrepEach, w.grep = true, regexp.MustCompile("ERROR")
+
+	rule := w.rules[0]
+	w.collapseLatest(&Trigger{Event: &fsnotify.FileEvent{Name: "app.log"}, Rule: rule, Stdin: []byte("ERROR one\n"), Line: "ERROR one", Grepped: true})
+	w.collapseLatest(&Trigger{Event: &fsnotify.FileEvent{Name: "app.log"}, Rule: rule, Stdin: []byte("ERROR two\n"), Line: "ERROR two", Grepped: true})
+	if stdin := string(w.latest[0].Stdin); stdin != "ERROR one\nERROR two\n" {
+		t.Fatalf("the lines of the collapsed events should be kept, got %q", stdin)
+	}
+
+	w.runLatest()
+	if commands := w.Stats().Commands; commands != 2 {
+		t.Errorf("-grep-each should run the commands once per collapsed line, got %d commands", commands)
+	}
+}
+
+func TestEvaluateEmptyLine(t *testing.T) {
+	e := &Executor{}
+	trigger := &Trigger{Event: &fsnotify.FileEvent{Name: "app.log"}, Grepped: true}
+	if actual := e.evaluateVariables("echo [%line]", trigger); actual != "echo []" {
+		t.Errorf("an empty matching line should be expanded, got %q", actual)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// HashAdler32 is a fast checksum of the content, the default strategy
+	HashAdler32 = "adler32"
+	// HashSHA256 is a strong hash of the content, for the files whose changes must not be missed
+	HashSHA256 = "sha256"
+	// HashSize compares the size and the modification time without reading the content, for large files such as logs
+	HashSize = "size"
+)
+
+// Hasher returns the digest of a file, compared to the cached one to detect content changes
+type Hasher func(path string) (sum string, err error)
+
+var hashStrategies = map[string]Hasher{HashAdler32: getContentHash, HashSHA256: getSHA256Hash, HashSize: getSizeHash}
+
+// NewHasher returns the hasher applying the strategy mapped to the extension of each file (e.g. ".log": "size"), the
+// other files use the default strategy, adler32 when empty
+func NewHasher(strategy string, byExt map[string]string) (hasher Hasher, err error) {
+	if strategy == "" {
+		strategy = HashAdler32
+	}
+	for _, name := range append([]string{strategy}, mapValues(byExt)...) {
+		if _, found := hashStrategies[name]; !found {
+			err = &OptionError{"hash strategy", name}
+			return
+		}
+	}
+	if len(byExt) == 0 {
+		return hashStrategies[strategy], nil
+	}
+
+	hasher = func(path string) (string, error) {
+		return hashStrategies[hashStrategyFor(byExt, path, strategy)](path)
+	}
+	return
+}
+
+// hashStrategyFor returns the strategy mapped to the extension of the file, with or without its leading dot, or the
+// default strategy
+func hashStrategyFor(byExt map[string]string, path string, defaultStrategy string) string {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return defaultStrategy
+	}
+	for mapped, strategy := range byExt {
+		if !strings.HasPrefix(mapped, ".") {
+			mapped = "." + mapped
+		}
+		if ext == mapped || (caseInsensitiveFS && strings.EqualFold(ext, mapped)) {
+			Tracef("file %s, hash strategy: %s", path, strategy)
+			return strategy
+		}
+	}
+	return defaultStrategy
+}
+
+func getSHA256Hash(filename string) (sum string, err error) {
+	return hashContent(filename, sha256.New())
+}
+
+func getSizeHash(filename string) (sum string, err error) {
+	st, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	sum = fmt.Sprintf("%d:%d", st.Size(), st.ModTime().UnixNano())
+	return
+}
+
+func mapValues(m map[string]string) (values []string) {
+	for _, value := range m {
+		values = append(values, value)
+	}
+	return
+}
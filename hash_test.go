@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHashStrategyFor(t *testing.T) {
+	byExt := map[string]string{".log": HashSize, "bin": HashSHA256}
+	for path, expected := range map[string]string{
+		"logs/app.log":  HashSize,
+		"build/out.bin": HashSHA256,
+		"main.go":       HashAdler32,
+		"Makefile":      HashAdler32,
+		"app.log.gz":    HashAdler32,
+	} {
+		if actual := hashStrategyFor(byExt, path, HashAdler32); actual != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, actual)
+		}
+	}
+	if actual := hashStrategyFor(nil, "app.log", HashSHA256); actual != HashSHA256 {
+		t.Errorf("expected the default strategy without a mapping, got %s", actual)
+	}
+}
+
+func TestNewHasher(t *testing.T) {
+	if _, err := NewHasher("md5", nil); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected an error matching %q, got %v", ErrInvalidOption, err)
+	}
+	if _, err := NewHasher(HashAdler32, map[string]string{".log": "lines"}); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected an error matching %q, got %v", ErrInvalidOption, err)
+	}
+	if _, err := NewHasher("", map[string]string{".log": HashSize}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
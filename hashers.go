@@ -0,0 +1,112 @@
+package main
+
+import (
+	"hash/fnv"
+	"os"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+// HasherBufSize is the number of events waiting for each hasher
+const HasherBufSize = 1024
+
+// prehash is the content hash of the file of a modify event, computed by a hasher before the worker handles it
+type prehash struct {
+	sum string
+	err error
+}
+
+// hashJob is an event waiting for its hasher, done is closed once the file was hashed
+type hashJob struct {
+	evt  *fsnotify.FileEvent
+	done chan struct{}
+}
+
+// startHashers waits for the modified files to be closed and hashes them in -workers goroutines before passing their
+// events to the worker, which keeps handling the events serially. The events of a path always go through the same
+// hasher, so a file is never hashed twice at once, and the events are passed to the worker in the order they
+// arrived: an event waits for the hashing of the events before it. hashed is closed once events is closed and
+// drained.
+func (w *WatchService) startHashers(events <-chan *fsnotify.FileEvent, hashed chan<- *fsnotify.FileEvent) {
+	shards := make([]chan hashJob, w.config.Workers)
+	for i := range shards {
+		shards[i] = make(chan hashJob, HasherBufSize)
+		go func(shard <-chan hashJob) {
+			for job := range shard {
+				w.hashAhead(job.evt)
+				close(job.done)
+			}
+		}(shards[i])
+	}
+
+	ordered := make(chan hashJob, HasherBufSize)
+	go func() {
+		for evt := range events {
+			job := hashJob{evt, make(chan struct{})}
+			shards[shardOf(evt.Name, len(shards))] <- job
+			ordered <- job
+		}
+		for _, shard := range shards {
+			close(shard)
+		}
+		close(ordered)
+	}()
+
+	go func() {
+		for job := range ordered {
+			<-job.done
+			hashed <- job.evt
+		}
+		close(hashed)
+	}()
+}
+
+func shardOf(path string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32() % uint32(count))
+}
+
+// hashAhead hashes the file of a modify event matching a rule, the rules are never changed once compiled
+func (w *WatchService) hashAhead(evt *fsnotify.FileEvent) {
+	if !evt.IsModify() || w.isDir(evt.Name) || len(matchRules(w.rules, evt, w.relativeToRoot(evt.Name))) == 0 {
+		return
+	}
+	if st, err := os.Stat(evt.Name); err != nil || !st.Mode().IsRegular() {
+		return
+	}
+
+	var result prehash
+	if w.waitClose != nil {
+		result.err = w.waitClose(evt.Name)
+	}
+	if result.err == nil {
+		result.sum, result.err = w.hash(evt.Name)
+	}
+	w.prehashLock.Lock()
+	w.prehashed[evt] = result
+	w.prehashLock.Unlock()
+}
+
+// takePrehash returns and forgets the hash computed ahead for the event, if any
+func (w *WatchService) takePrehash(evt *fsnotify.FileEvent) (result prehash, found bool) {
+	w.prehashLock.Lock()
+	defer w.prehashLock.Unlock()
+	if result, found = w.prehashed[evt]; found {
+		delete(w.prehashed, evt)
+	}
+	return
+}
+
+// checkContentChanged compares the file against its cached entry, with the hash computed ahead for the event by
+// the hashers when there is one
+func (w *WatchService) checkContentChanged(evt *fsnotify.FileEvent, path string) bool {
+	waitClose, hash := w.waitClose, w.hash
+	if result, found := w.takePrehash(evt); found {
+		waitClose = nil
+		hash = func(string) (string, error) {
+			return result.sum, result.err
+		}
+	}
+	return checkFileContentChanged(w.entries, path, waitClose, hash)
+}
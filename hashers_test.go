@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestStartHashers(t *testing.T) {
+	w := &WatchService{config: &Config{Workers: 4}, prehashed: make(map[*fsnotify.FileEvent]prehash)}
+	events := make(chan *fsnotify.FileEvent)
+	hashed := make(chan *fsnotify.FileEvent, 100)
+	w.startHashers(events, hashed)
+
+	var sent []*fsnotify.FileEvent
+	for i := 0; i < 60; i++ {
+		evt := &fsnotify.FileEvent{Name: "file" + strconv.Itoa(i%6)}
+		sent = append(sent, evt)
+		events <- evt
+	}
+	close(events)
+
+	received := 0
+	for evt := range hashed {
+		if evt != sent[received] {
+			t.Fatalf("event %d was reordered, got %s", received, evt.Name)
+		}
+		received++
+	}
+	if received != len(sent) {
+		t.Errorf("expected %d events, got %d", len(sent), received)
+	}
+}
+
+func TestWorkersKeepOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Workers = 4
+	config.HistorySize = 100
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan *fsnotify.FileEvent)
+	hashed := make(chan *fsnotify.FileEvent, eventBufSize)
+	w.startHashers(events, hashed)
+	w.startWorker(hashed)
+
+	var sent []string
+	for i := 0; i < 20; i++ {
+		sub := filepath.Join(dir, "dir"+strconv.Itoa(i))
+		sent = append(sent, sub, filepath.Join(sub, "file"))
+		events <- &fsnotify.FileEvent{Name: sub}
+		events <- &fsnotify.FileEvent{Name: filepath.Join(sub, "file")}
+	}
+	close(events)
+	<-w.workerDone
+
+	history := w.History()
+	if len(history) != len(sent) {
+		t.Fatalf("expected %d processed events, got %d", len(sent), len(history))
+	}
+	for i, record := range history {
+		if record.Path != sent[i] {
+			t.Fatalf("event %d: expected %s, got %s, the events of different paths were reordered", i, sent[i], record.Path)
+		}
+	}
+}
+
+func TestTakePrehash(t *testing.T) {
+	w := &WatchService{prehashed: make(map[*fsnotify.FileEvent]prehash)}
+	evt := &fsnotify.FileEvent{Name: "main.go"}
+	w.prehashed[evt] = prehash{sum: "1"}
+	if result, found := w.takePrehash(evt); !found || result.sum != "1" {
+		t.Errorf("expected the hash computed ahead, got %v, %t", result, found)
+	}
+	if _, found := w.takePrehash(evt); found {
+		t.Error("the hash should be used once")
+	}
+}
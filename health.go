@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HealthShutdownTimeout bounds the health checks in progress when the service stops
+const HealthShutdownTimeout = time.Duration(5) * time.Second
+
+// Health is the JSON body of the /healthz and /readyz endpoints
+type Health struct {
+	Status      string  `json:"status"`
+	WatchedDirs int     `json:"watched_dirs"`
+	Uptime      float64 `json:"uptime_seconds"`
+}
+
+// startHealthServer serves /healthz, ok while the worker runs and the watches are established, and /readyz, ok once
+// the initial watches are registered, on the -health-addr
+func (w *WatchService) startHealthServer() (server *http.Server, err error) {
+	listener, err := net.Listen("tcp", w.config.HealthAddr)
+	if err != nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", w.healthHandler(w.alive))
+	mux.HandleFunc("/readyz", w.healthHandler(w.isReady))
+	server = &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Println("the health server stopped:", err)
+		}
+	}()
+	Logln("serving the health checks on", listener.Addr())
+	return
+}
+
+func stopHealthServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), HealthShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("cannot stop the health server:", err)
+	}
+}
+
+func (w *WatchService) healthHandler(check func() bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		health := &Health{Status: "ok", WatchedDirs: len(w.WatchedDirs()), Uptime: time.Since(w.startedAt).Seconds()}
+		status := http.StatusOK
+		if !check() {
+			health.Status, status = "unavailable", http.StatusServiceUnavailable
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(health)
+	}
+}
+
+// isReady indicates the initial watches are registered and the service was not stopped
+func (w *WatchService) isReady() bool {
+	select {
+	case <-w.done:
+		return false
+	case <-w.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// alive indicates the worker runs and a directory is watched, the paths piped to -stdin may not have arrived yet
+func (w *WatchService) alive() bool {
+	select {
+	case <-w.workerDone:
+		return false
+	default:
+	}
+	return w.isReady() && (len(w.WatchedDirs()) > 0 || w.config.WatchStdin)
+}
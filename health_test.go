@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(handler http.HandlerFunc, expected int) (health Health) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != expected {
+			t.Errorf("expected the status %d, got %d", expected, rec.Code)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+			t.Error(err)
+		}
+		return
+	}
+
+	check(w.healthHandler(w.isReady), http.StatusServiceUnavailable)
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	check(w.healthHandler(w.isReady), http.StatusOK)
+	if health := check(w.healthHandler(w.alive), http.StatusOK); health.WatchedDirs != 1 {
+		t.Errorf("expected 1 watched directory, got %d", health.WatchedDirs)
+	}
+
+	w.Stop()
+	check(w.healthHandler(w.alive), http.StatusServiceUnavailable)
+}
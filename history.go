@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+)
+
+// History is a ring buffer of the last processed events
+type History struct {
+	lock    sync.Mutex
+	entries []EventRecord
+	next    int
+	full    bool
+}
+
+// NewHistory creates a history keeping the last size entries, a size of 0 (or less) keeps nothing
+func NewHistory(size int) *History {
+	if size < 0 {
+		size = 0
+	}
+	return &History{entries: make([]EventRecord, size)}
+}
+
+// Add appends the entry, replacing the oldest one when the history is full
+func (h *History) Add(entry EventRecord) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.entries) == 0 {
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Entries returns a copy of the entries, the oldest first
+func (h *History) Entries() (entries []EventRecord) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.full {
+		entries = append(entries, h.entries[h.next:]...)
+	}
+	return append(entries, h.entries[:h.next]...)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	paths := func(entries []EventRecord) (paths []string) {
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		return
+	}
+
+	history := NewHistory(3)
+	for _, path := range []string{"a", "b"} {
+		history.Add(EventRecord{Path: path})
+	}
+	if actual := paths(history.Entries()); !reflect.DeepEqual(actual, []string{"a", "b"}) {
+		t.Errorf("expected [a b], got %v", actual)
+	}
+
+	for _, path := range []string{"c", "d", "e"} {
+		history.Add(EventRecord{Path: path})
+	}
+	if actual := paths(history.Entries()); !reflect.DeepEqual(actual, []string{"c", "d", "e"}) {
+		t.Errorf("expected the oldest entries to be replaced, got %v", actual)
+	}
+
+	empty := NewHistory(0)
+	empty.Add(EventRecord{Path: "a"})
+	if entries := empty.Entries(); len(entries) != 0 {
+		t.Errorf("expected an empty history, got %v", entries)
+	}
+}
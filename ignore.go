@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the file in the watch root holding the ignore rules (gitignore syntax)
+const IgnoreFile = ".watchfignore"
+
+type ignoreRule struct {
+	pattern  *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// IgnoreMatcher decides whether a path is ignored using gitignore style rules, the last matching rule wins
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+// LoadIgnoreFile creates an IgnoreMatcher from an ignore file, a missing file results in a matcher ignoring nothing
+func LoadIgnoreFile(filename string) (matcher *IgnoreMatcher, err error) {
+	matcher = &IgnoreMatcher{}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if errRule := matcher.AddRule(scanner.Text()); errRule != nil {
+			err = fmt.Errorf("%s:%d: %s", filename, lineNo, errRule)
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// AddRule parses a single line of an ignore file, blank lines and comments are skipped
+func (m *IgnoreMatcher) AddRule(line string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+
+	pattern, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid glob %q: %s", line, err)
+	}
+	rule.pattern = pattern
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+// Match reports whether the path (relative to the watch root) is ignored, a path inside an ignored directory is ignored too
+func (m *IgnoreMatcher) Match(path string, isDir bool) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." {
+		return false
+	}
+
+	elements := strings.Split(path, "/")
+	for i := 1; i < len(elements); i++ {
+		if m.matchOne(strings.Join(elements[:i], "/"), elements[i-1], true) {
+			return true
+		}
+	}
+	return m.matchOne(path, elements[len(elements)-1], isDir)
+}
+
+func (m *IgnoreMatcher) matchOne(path string, name string, isDir bool) (ignored bool) {
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := name
+		if rule.anchored {
+			target = path
+		}
+		if rule.pattern.MatchString(target) {
+			ignored = !rule.negate
+		}
+	}
+	return
+}
+
+// globToRegexp converts a gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var buf strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			buf.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			buf.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			buf.WriteString(".*")
+			i++
+		case c == '*':
+			buf.WriteString("[^/]*")
+		case c == '?':
+			buf.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(glob[i:], ']'); end > 0 {
+				class := glob[i+1 : i+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				buf.WriteString("[" + class + "]")
+				i += end
+			} else {
+				buf.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	matcher := &IgnoreMatcher{}
+	for _, line := range []string{
+		"# comment",
+		"",
+		"*.swp",
+		"build/",
+		"/vendor",
+		"docs/**/*.html",
+		"*.log",
+		"!important.log",
+	} {
+		if err := matcher.AddRule(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"main.go", false, false},
+		{".main.go.swp", false, true},
+		{"src/.main.go.swp", false, true},
+		{"build", true, true},
+		{"build", false, false},
+		{"src/build/out.o", false, true},
+		{"vendor/lib/lib.go", false, true},
+		{"src/vendor/lib.go", false, false},
+		{"docs/index.html", false, true},
+		{"docs/api/v1/index.html", false, true},
+		{"site/docs/index.html", false, false},
+		{"debug.log", false, true},
+		{"important.log", false, false},
+		{"logs/important.log", false, false},
+		{"./debug.log", false, true},
+		{".", true, false},
+	}
+
+	for _, test := range tests {
+		if actual := matcher.Match(test.path, test.isDir); actual != test.expected {
+			t.Errorf("Match(%q, %v) = %v, expected %v", test.path, test.isDir, actual, test.expected)
+		}
+	}
+}
+
+func TestLoadIgnoreFileMissing(t *testing.T) {
+	matcher, err := LoadIgnoreFile("does-not-exist/" + IgnoreFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matcher.Match("main.go", false) {
+		t.Fatal("an empty matcher should not ignore anything")
+	}
+}
+
+func TestLoadIgnoreFileInvalidGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, IgnoreFile)
+	if err := ioutil.WriteFile(filename, []byte("*.swp\n[z-a]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIgnoreFile(filename); err == nil || !strings.Contains(err.Error(), filename+":2:") {
+		t.Errorf("expected an error naming the file and the line, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// LogsTailLines is the number of existing lines printed before following the log file
+	LogsTailLines = 10
+	// LogsPollInterval is how often the log file is checked for new content
+	LogsPollInterval = time.Duration(250) * time.Millisecond
+)
+
+// followFile prints the last lines of the file and then the content appended to it, like tail -f
+func followFile(filename string, out io.Writer, lines int) (err error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	if _, err = out.Write(lastLines(content, lines)); err != nil {
+		return
+	}
+
+	entry := &FileEntry{size: int64(len(content)), offset: int64(len(content))}
+	for {
+		time.Sleep(LogsPollInterval)
+
+		if entry.size, err = getFileSize(filename); err != nil {
+			if os.IsNotExist(err) {
+				// the log file may be rotated, wait for it to reappear
+				continue
+			}
+			return
+		}
+
+		appended, errRead := readAppendedContent(entry, filename)
+		if errRead != nil {
+			return errRead
+		}
+		if _, err = out.Write(appended); err != nil {
+			return
+		}
+	}
+}
+
+func lastLines(content []byte, n int) []byte {
+	end := len(content)
+	if end > 0 && content[end-1] == '\n' {
+		end--
+	}
+	idx := end
+	for i := 0; i < n; i++ {
+		idx = bytes.LastIndexByte(content[:idx], '\n')
+		if idx < 0 {
+			return content
+		}
+	}
+	return content[idx+1:]
+}
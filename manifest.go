@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadManifest reads the list of files to watch, one path per line, blank lines and lines starting with # are ignored
+func LoadManifest(filename string) (paths map[string]bool, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	paths = make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths[filepath.Clean(line)] = true
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestManifestFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "inputs.txt")
+	content := "# build inputs\n" + filepath.Join(dir, "main.go") + "\n\n" + filepath.Join(dir, "assets") + "/\n"
+	if err := ioutil.WriteFile(manifest, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.FromFile = manifest
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.manifest, err = LoadManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.manifest) != 2 {
+		t.Fatalf("expected the 2 listed paths, got %v", w.manifest)
+	}
+
+	tests := []struct {
+		path   string
+		passed bool
+	}{
+		{filepath.Join(dir, "main.go"), true},
+		{dir + "/./main.go", true},
+		{filepath.Join(dir, "assets", "logo.png"), true},
+		{filepath.Join(dir, "util.go"), false},
+		{filepath.Join(dir, "assets", "icons", "logo.png"), false},
+	}
+	for _, test := range tests {
+		if passed := w.passesFilters(&fsnotify.FileEvent{Name: test.path}); passed != test.passed {
+			t.Errorf("%s: expected %t, got %t", test.path, test.passed, passed)
+		}
+	}
+}
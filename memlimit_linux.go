@@ -0,0 +1,21 @@
+// +build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const memLimitSupported = true
+
+// limitMemory sets the address space limit (RLIMIT_AS) of the process, allocations beyond it fail
+func limitMemory(pid int, limit int64) error {
+	rlimit := syscall.Rlimit{Cur: uint64(limit), Max: uint64(limit)}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), syscall.RLIMIT_AS,
+		uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// +build freebsd openbsd netbsd darwin linux
+
+package main
+
+import (
+	"log"
+	"os/exec"
+	"syscall"
+)
+
+// startWithNice starts the command and lowers (or raises) its scheduling priority by the niceness
+func startWithNice(cmd *exec.Cmd, nice int) (err error) {
+	if err = cmd.Start(); err != nil || nice == 0 {
+		return
+	}
+	if errNice := syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, nice); errNice != nil {
+		log.Printf("cannot set the niceness of %s to %d: %s", cmd.Path, nice, errNice)
+	}
+	return
+}
@@ -0,0 +1,37 @@
+// +build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const (
+	idlePriorityClass        = 0x00000040
+	belowNormalPriorityClass = 0x00004000
+	aboveNormalPriorityClass = 0x00008000
+	highPriorityClass        = 0x00000080
+)
+
+// startWithNice starts the command with the priority class closest to the niceness
+func startWithNice(cmd *exec.Cmd, nice int) (err error) {
+	var priorityClass uint32
+	switch {
+	case nice >= 15:
+		priorityClass = idlePriorityClass
+	case nice > 0:
+		priorityClass = belowNormalPriorityClass
+	case nice <= -10:
+		priorityClass = highPriorityClass
+	case nice < 0:
+		priorityClass = aboveNormalPriorityClass
+	}
+	if priorityClass != 0 {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.CreationFlags |= priorityClass
+	}
+	return cmd.Start()
+}
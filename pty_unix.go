@@ -0,0 +1,44 @@
+// +build freebsd openbsd netbsd darwin linux
+
+package main
+
+import (
+	"io"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+const ptySupported = true
+
+// attachPty connects the output of the command (and its input unless it is given one) to a new pseudo-terminal
+// relayed to the writer, the returned release is called once the command was waited for
+func attachPty(cmd *exec.Cmd, output io.Writer) (release func(), err error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return
+	}
+
+	if cmd.Stdin == nil {
+		cmd.Stdin = tty
+	}
+	cmd.Stdout, cmd.Stderr = tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: cmd.Stdin == tty}
+
+	copied := make(chan struct{})
+	go func() {
+		// the read fails once every end of the terminal is closed
+		io.Copy(output, ptmx)
+		ptmx.Close()
+		close(copied)
+	}()
+
+	release = func() {
+		tty.Close()
+		if cmd.ProcessState != nil {
+			<-copied
+		}
+	}
+	return
+}
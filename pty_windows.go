@@ -0,0 +1,15 @@
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+const ptySupported = false
+
+func attachPty(cmd *exec.Cmd, output io.Writer) (release func(), err error) {
+	return nil, errors.New("pseudo-terminals are not supported on windows")
+}
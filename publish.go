@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultNATSPort and DefaultRedisPort are used when the -publish-to URL does not specify a port
+	DefaultNATSPort  = "4222"
+	DefaultRedisPort = "6379"
+
+	// PublishBufSize is the number of events waiting for the broker before the new ones are dropped
+	PublishBufSize = 1024
+	// PublishTimeout bounds the connection and each write to the broker
+	PublishTimeout = time.Duration(5) * time.Second
+	// PublishMaxBackoff bounds the delay between the reconnections to the broker
+	PublishMaxBackoff = time.Duration(30) * time.Second
+)
+
+// Publisher sends the matched events as JSON to a NATS subject or a Redis channel, a lost connection is
+// re-established with backoff and the events are queued meanwhile
+type Publisher struct {
+	// Scheme is nats or redis, Topic the subject or the channel
+	Scheme string
+	Host   string
+	Topic  string
+
+	username string
+	password string
+
+	events chan []byte
+	done   chan bool
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+}
+
+// NewPublisher creates a Publisher for a URL such as nats://[user:pass@]host[:port]/subject or
+// redis://[:pass@]host[:port]/channel, the topic defaults to the program name
+func NewPublisher(rawurl string) (publisher *Publisher, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+
+	publisher = &Publisher{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Topic:  strings.Trim(u.Path, "/"),
+		events: make(chan []byte, PublishBufSize),
+		done:   make(chan bool),
+	}
+	switch u.Scheme {
+	case "nats":
+		if u.Port() == "" {
+			publisher.Host = net.JoinHostPort(u.Hostname(), DefaultNATSPort)
+		}
+	case "redis":
+		if u.Port() == "" {
+			publisher.Host = net.JoinHostPort(u.Hostname(), DefaultRedisPort)
+		}
+	default:
+		return nil, &OptionError{"publish scheme", u.Scheme}
+	}
+	if publisher.Topic == "" {
+		publisher.Topic = Program
+	}
+	if u.User != nil {
+		publisher.username = u.User.Username()
+		publisher.password, _ = u.User.Password()
+	}
+
+	go publisher.loop()
+	return
+}
+
+// Publish queues the event without blocking the worker, the event is dropped when the queue is full
+func (p *Publisher) Publish(record *EventRecord) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	select {
+	case p.events <- payload:
+	default:
+		Logf("the publish queue is full, %s: %s dropped", record.Event, record.Path)
+	}
+}
+
+// Close stops publishing, the queued events are discarded
+func (p *Publisher) Close() {
+	close(p.done)
+}
+
+func (p *Publisher) loop() {
+	backoff := time.Second
+	for {
+		var payload []byte
+		select {
+		case payload = <-p.events:
+		case <-p.done:
+			p.disconnect()
+			return
+		}
+
+		for {
+			err := p.send(payload)
+			if err == nil {
+				backoff = time.Second
+				break
+			}
+			log.Printf("cannot publish to %s://%s, retrying in %s: %s", p.Scheme, p.Host, backoff, err)
+			p.disconnect()
+			select {
+			case <-time.After(backoff):
+			case <-p.done:
+				return
+			}
+			if backoff *= 2; backoff > PublishMaxBackoff {
+				backoff = PublishMaxBackoff
+			}
+		}
+	}
+}
+
+func (p *Publisher) send(payload []byte) (err error) {
+	if p.conn == nil {
+		if err = p.connect(); err != nil {
+			return
+		}
+	}
+
+	if p.Scheme == "nats" {
+		return p.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", p.Topic, len(payload), payload))
+	}
+	if err = p.write(redisCommand("PUBLISH", p.Topic, string(payload))); err != nil {
+		return
+	}
+	return p.readRedisReply()
+}
+
+func (p *Publisher) connect() (err error) {
+	conn, err := net.DialTimeout("tcp", p.Host, PublishTimeout)
+	if err != nil {
+		return
+	}
+	p.conn, p.reader = conn, bufio.NewReader(conn)
+
+	if p.Scheme == "nats" {
+		// the server greets with its INFO, then the replies are only read to answer its pings
+		conn.SetReadDeadline(time.Now().Add(PublishTimeout))
+		if _, err = p.reader.ReadString('\n'); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		options := map[string]interface{}{"verbose": false, "pedantic": false, "name": Program}
+		if p.username != "" {
+			options["user"], options["pass"] = p.username, p.password
+		}
+		rawOptions, _ := json.Marshal(options)
+		if err = p.write("CONNECT " + string(rawOptions) + "\r\n"); err != nil {
+			return
+		}
+		go p.answerPings(conn, p.reader)
+		return
+	}
+
+	if p.password != "" {
+		if err = p.write(redisCommand("AUTH", p.password)); err != nil {
+			return
+		}
+		err = p.readRedisReply()
+	}
+	return
+}
+
+func (p *Publisher) disconnect() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.reader = nil, nil
+	}
+}
+
+func (p *Publisher) write(data string) (err error) {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(PublishTimeout))
+	_, err = p.conn.Write([]byte(data))
+	return
+}
+
+// answerPings replies to the pings of the NATS server, which closes the connections that do not
+func (p *Publisher) answerPings(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			p.writeLock.Lock()
+			conn.Write([]byte("PONG\r\n"))
+			p.writeLock.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("nats://%s: %s", p.Host, strings.TrimSpace(line))
+		}
+	}
+}
+
+func (p *Publisher) readRedisReply() (err error) {
+	p.conn.SetReadDeadline(time.Now().Add(PublishTimeout))
+	line, err := p.reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(line, "-") {
+		err = fmt.Errorf("redis: %s", strings.TrimSpace(line[1:]))
+	}
+	return
+}
+
+// redisCommand encodes the command as a RESP array of bulk strings
+func redisCommand(args ...string) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return b.String()
+}
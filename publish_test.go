@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBroker accepts a connection, greets it and returns the lines received until the expected one
+func fakeBroker(t *testing.T, greeting string, reply string, expected string) (addr string, received chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received = make(chan []string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(greeting))
+
+		var lines []string
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			lines = append(lines, strings.TrimSpace(line))
+			if strings.Contains(line, expected) {
+				conn.Write([]byte(reply))
+				break
+			}
+		}
+		received <- lines
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestPublisher(t *testing.T) {
+	record := &EventRecord{Event: "ENTRY_MODIFY", Path: "main.go", Matched: true}
+	tests := []struct {
+		scheme   string
+		greeting string
+		reply    string
+		expected []string
+	}{
+		{"nats", "INFO {}\r\n", "", []string{"PUB builds ", `"path":"main.go"`}},
+		{"redis", "", ":1\r\n", []string{"PUBLISH", "builds", `"path":"main.go"`}},
+	}
+
+	for _, test := range tests {
+		addr, received := fakeBroker(t, test.greeting, test.reply, `"path":"main.go"`)
+		publisher, err := NewPublisher(test.scheme + "://" + addr + "/builds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		publisher.Publish(record)
+
+		select {
+		case lines := <-received:
+			all := strings.Join(lines, "\n")
+			for _, expected := range test.expected {
+				if !strings.Contains(all, expected) {
+					t.Errorf("%s: expected %q in %q", test.scheme, expected, all)
+				}
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s: the event was not published", test.scheme)
+		}
+		publisher.Close()
+	}
+
+	if _, err := NewPublisher("kafka://localhost/builds"); err == nil {
+		t.Error("expected an error for an unknown scheme")
+	}
+}
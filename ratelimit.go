@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+)
+
+// TokenBucket limits the runs of the commands to Rate per second on average, allowing bursts of up to Burst runs
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+
+	tokens float64
+	last   time.Time
+}
+
+// Available indicates a run is allowed at now
+func (b *TokenBucket) Available(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+// Runs returns the number of runs allowed at now, the whole tokens in the bucket
+func (b *TokenBucket) Runs(now time.Time) int {
+	b.refill(now)
+	if b.tokens < 1 {
+		return 0
+	}
+	return int(b.tokens)
+}
+
+// Take spends a token for a run at now, runs forced through without a token are paid back by the next tokens
+func (b *TokenBucket) Take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	burst := float64(b.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.Rate
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
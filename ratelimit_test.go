@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := &TokenBucket{Rate: 1, Burst: 3}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !bucket.Available(now) {
+			t.Fatalf("run %d of the burst should be allowed", i+1)
+		}
+		bucket.Take(now)
+	}
+	if bucket.Available(now) {
+		t.Fatal("the burst is spent, the run should be limited")
+	}
+
+	now = now.Add(time.Second)
+	if !bucket.Available(now) {
+		t.Fatal("a token should be refilled after a second")
+	}
+	bucket.Take(now)
+	if bucket.Available(now.Add(500 * time.Millisecond)) {
+		t.Fatal("half a token is not a run")
+	}
+
+	if !bucket.Available(now.Add(time.Hour)) {
+		t.Fatal("the bucket should be refilled")
+	}
+	if runs := bucket.Runs(now.Add(time.Hour)); runs != 3 {
+		t.Errorf("expected the burst of 3 runs, got %d", runs)
+	}
+	bucket.tokens = 0
+	bucket.last = now
+	if bucket.refill(now.Add(time.Hour)); bucket.tokens != 3 {
+		t.Errorf("the tokens should be capped by the burst, got %v", bucket.tokens)
+	}
+}
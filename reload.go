@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// ReloadingService is the service managed by the daemon, it is replaced when the configuration file changes with
+// -watch-config
+type ReloadingService struct {
+	*WatchService
+
+	// lock guards the swap of the WatchService against the pause signal handler
+	lock sync.RWMutex
+	// loaded is the content of the configuration file the running service was built from
+	loaded []byte
+	// failed indicates neither the reloaded nor the previous configuration could be started, nothing runs
+	failed bool
+}
+
+// NewReloadingService creates the service, remembering the configuration file content when it is watched
+func NewReloadingService(config *Config) (service *ReloadingService, err error) {
+	watchService, err := NewTargetService(config)
+	if err != nil {
+		return
+	}
+	service = &ReloadingService{WatchService: watchService}
+	if config.WatchConfig {
+		service.loaded, _ = ioutil.ReadFile(configFile)
+	}
+	return
+}
+
+// Reload replaces the running service with one built from the configuration file, a configuration that cannot be
+// loaded or validated keeps the running service. A file with the same content is not reloaded, so that a command
+// rewriting the configuration cannot start a reload loop. The pause state is kept. An error is returned when
+// neither the reloaded nor the previous configuration could be started, nothing runs then.
+func (s *ReloadingService) Reload() (err error) {
+	rawdata, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		log.Printf("cannot read %s, keeping the running configuration: %s", configFile, err)
+		return nil
+	}
+	if bytes.Equal(rawdata, s.loaded) {
+		Logf("%s content is unchanged, not reloaded", configFile)
+		return
+	}
+	s.loaded = rawdata
+
+	config, err := LoadConfigFromFile()
+	if err == nil {
+		err = loadCommandsFile(config)
+	}
+	var service *WatchService
+	if err == nil {
+		service, err = NewTargetService(config)
+	}
+	if err != nil {
+		log.Printf("cannot reload %s, keeping the running configuration: %s", configFile, err)
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	paused := s.WatchService.Paused()
+	if err = s.WatchService.Stop(); err != nil {
+		log.Println("cannot stop the running service:", err)
+	}
+	if paused {
+		service.Pause()
+	}
+	if err = service.Start(); err != nil {
+		log.Printf("cannot start the reloaded configuration, restarting the previous one: %s", err)
+		if service, err = NewTargetService(s.config); err == nil {
+			if paused {
+				service.Pause()
+			}
+			err = service.Start()
+		}
+		if err != nil {
+			s.failed = true
+			return fmt.Errorf("cannot restart the previous configuration: %w", err)
+		}
+	}
+	s.WatchService = service
+	log.Println(configFile, "reloaded")
+	return
+}
+
+// Stop stops the running service, nothing runs after a failed reload
+func (s *ReloadingService) Stop() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.failed {
+		return nil
+	}
+	return s.WatchService.Stop()
+}
+
+// Pause suppresses the commands of the running service
+func (s *ReloadingService) Pause() {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.WatchService.Pause()
+}
+
+// Resume runs the commands of the running service again
+func (s *ReloadingService) Resume() {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.WatchService.Resume()
+}
+
+// RunNow requests a manual run of the commands of the running service
+func (s *ReloadingService) RunNow() {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.WatchService.RunNow()
+}
+
+// Paused indicates the commands of the running service are suppressed
+func (s *ReloadingService) Paused() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.WatchService.Paused()
+}
+
+// isConfigFile indicates the path is the configuration file watched with -watch-config, its changes are not events
+func (w *WatchService) isConfigFile(path string) bool {
+	return w.config.WatchConfig && filepath.Clean(path) == filepath.Clean(configFile)
+}
+
+// configFileChanged signals the configuration change and watches the file again, a file replaced by an editor is
+// a new file
+func (w *WatchService) configFileChanged() {
+	select {
+	case w.configChanged <- true:
+	default:
+	}
+	if !w.isDir(filepath.Dir(configFile)) {
+		if err := w.watcher.Watch(configFile); err != nil {
+			Logln("cannot watch the configuration file:", err)
+		}
+	}
+}
+
+// ConfigChanged returns a channel receiving a value when the configuration file changed (with -watch-config)
+func (w *WatchService) ConfigChanged() <-chan bool {
+	return w.configChanged
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReloadKeepsRunningConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"Events": ["explode"]}`)
+	f.Close()
+
+	savedConfigFile := configFile
+	configFile = f.Name()
+	defer func() { configFile = savedConfigFile }()
+
+	w := &WatchService{config: defaultConfig}
+	service := &ReloadingService{WatchService: w}
+	service.Reload()
+	if service.WatchService != w {
+		t.Fatal("an invalid configuration should keep the running service")
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"Events": ["all"]}`), 0644)
+	service.loaded = []byte(`{"Events": ["all"]}`)
+	service.Reload()
+	if service.WatchService != w {
+		t.Fatal("an unchanged configuration should not be reloaded")
+	}
+}
+
+func TestReloadKeepsPaused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	rawdata, _ := json.Marshal(map[string]interface{}{"Target": dir, "NoSummary": true})
+	f.Write(rawdata)
+	f.Close()
+
+	savedConfigFile := configFile
+	configFile = f.Name()
+	defer func() { configFile = savedConfigFile }()
+
+	config := *defaultConfig
+	config.NoSummary = true
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	service := &ReloadingService{WatchService: w}
+	service.Pause()
+
+	if err = service.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Stop()
+	if service.WatchService == w {
+		t.Fatal("the changed configuration should be reloaded")
+	}
+	if !service.Paused() {
+		t.Error("the reloaded service should stay paused")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultSSHPort is used when the remote host does not specify a port
+const DefaultSSHPort = "22"
+
+// RemoteExecutor runs commands on a remote host over SSH, the connection is reused across events
+type RemoteExecutor struct {
+	Host           string
+	User           string
+	KeyFile        string
+	KnownHostsFile string
+
+	client *ssh.Client
+	lock   sync.Mutex
+}
+
+// NewRemoteExecutor creates a RemoteExecutor, host may be given as [user@]host[:port]
+func NewRemoteExecutor(host string, username string, keyFile string) (remote *RemoteExecutor, err error) {
+	if idx := strings.LastIndex(host, "@"); idx >= 0 {
+		if username == "" {
+			username = host[:idx]
+		}
+		host = host[idx+1:]
+	}
+	if _, _, errSplit := net.SplitHostPort(host); errSplit != nil {
+		host = net.JoinHostPort(host, DefaultSSHPort)
+	}
+
+	home := os.Getenv("HOME")
+	if username == "" {
+		current, errUser := user.Current()
+		if errUser != nil {
+			err = errUser
+			return
+		}
+		username = current.Username
+	}
+	if keyFile == "" {
+		keyFile = filepath.Join(home, ".ssh", "id_rsa")
+	}
+
+	remote = &RemoteExecutor{
+		Host:           host,
+		User:           username,
+		KeyFile:        keyFile,
+		KnownHostsFile: filepath.Join(home, ".ssh", "known_hosts"),
+	}
+	return
+}
+
+func (r *RemoteExecutor) connect() (client *ssh.Client, err error) {
+	if r.client != nil {
+		return r.client, nil
+	}
+
+	key, err := ioutil.ReadFile(r.KeyFile)
+	if err != nil {
+		return
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return
+	}
+	hostKeyCallback, err := knownhosts.New(r.KnownHostsFile)
+	if err != nil {
+		return
+	}
+
+	Logln("connecting to", r.User+"@"+r.Host)
+	r.client, err = ssh.Dial("tcp", r.Host, &ssh.ClientConfig{
+		User:            r.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	return r.client, err
+}
+
+func (r *RemoteExecutor) newSession() (session *ssh.Session, err error) {
+	client, err := r.connect()
+	if err != nil {
+		return
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		// the connection may have been dropped, reconnect once
+		Logln("reconnecting to", r.Host, "caused by:", err)
+		r.client.Close()
+		r.client = nil
+		if client, err = r.connect(); err != nil {
+			return
+		}
+		session, err = client.NewSession()
+	}
+	return
+}
+
+// Run runs the command through the remote shell, streaming its output back
+func (r *RemoteExecutor) Run(command string, env []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	r.lock.Lock()
+	session, err := r.newSession()
+	r.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("ssh %s: %s", r.Host, err)
+	}
+	defer session.Close()
+
+	// sshd usually rejects Setenv, so variables are exported by the remote shell instead
+	var exports []string
+	for _, variable := range env {
+		exports = append(exports, "export "+shellQuote(variable)+";")
+	}
+	if len(exports) > 0 {
+		command = strings.Join(exports, " ") + " " + command
+	}
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(command)
+}
+
+// Close closes the connection to the remote host
+func (r *RemoteExecutor) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.client == nil {
+		return nil
+	}
+	err := r.client.Close()
+	r.client = nil
+	return err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
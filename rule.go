@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+// RuleConfig models a rule of the configuration file: the commands to run for the events matching the pattern, at
+// most once per interval (in nanoseconds, 0 uses -i)
+type RuleConfig struct {
+	Pattern  string
+	Events   CommaStringSet
+	Commands StringSet
+	Interval time.Duration
+}
+
+// Rule is a compiled RuleConfig
+type Rule struct {
+	index      int
+	pattern    *regexp.Regexp
+	watchFlags map[string]EventBit
+	commands   []string
+	interval   time.Duration
+
+	// lastExec is when the commands of the rule last ran, the interval of each rule is limited on its own
+	lastExec time.Time
+}
+
+// compileRules compiles the rules of the config, the flat pattern/events/commands options form a single rule when
+// no rules are configured
+func compileRules(config *Config) (rules []*Rule, err error) {
+	ruleConfigs := config.Rules
+	if len(ruleConfigs) == 0 {
+		ruleConfigs = []RuleConfig{{config.IncludePattern, config.Events, config.Commands, 0}}
+	}
+
+	for i, ruleConfig := range ruleConfigs {
+		rule := &Rule{index: i, commands: ruleConfig.Commands, interval: ruleConfig.Interval}
+		if rule.interval == 0 {
+			rule.interval = config.Interval
+		}
+
+		events := ruleConfig.Events
+		if len(events) == 0 {
+			events = []string{"all"}
+		}
+		if rule.watchFlags, err = validateWatchFlags(events); err != nil {
+			err = fmt.Errorf("rule %d: %w", i, err)
+			return
+		}
+
+		pattern := ruleConfig.Pattern
+		if pattern == "" {
+			pattern = ".*"
+		}
+		if rule.pattern, err = regexp.Compile(pattern); err != nil {
+			err = fmt.Errorf("rule %d: %w", i, &PatternError{pattern, err})
+			return
+		}
+
+		rules = append(rules, rule)
+	}
+	return
+}
+
+// matchRules returns the rules matching the event, in order, the patterns match the path relative to the watch root
+func matchRules(rules []*Rule, evt *fsnotify.FileEvent, path string) (matched []*Rule) {
+	for _, rule := range rules {
+		if checkPatternMatching(rule.pattern, path) && checkEventType(rule.watchFlags, evt) {
+			matched = append(matched, rule)
+		}
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleWindow is a daily window of local time (e.g. 09:00-18:00), a window ending before it starts spans midnight
+type ScheduleWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// ParseSchedule parses windows formatted as HH:MM-HH:MM
+func ParseSchedule(windows []string) (schedule []ScheduleWindow, err error) {
+	for _, window := range windows {
+		bounds := strings.Split(window, "-")
+		if len(bounds) != 2 {
+			err = fmt.Errorf("the schedule window %s is not formatted as HH:MM-HH:MM", window)
+			return
+		}
+
+		var scheduleWindow ScheduleWindow
+		if scheduleWindow.start, err = parseClock(bounds[0]); err != nil {
+			return
+		}
+		if scheduleWindow.end, err = parseClock(bounds[1]); err != nil {
+			return
+		}
+		schedule = append(schedule, scheduleWindow)
+	}
+	return
+}
+
+func parseClock(clock string) (offset time.Duration, err error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		err = fmt.Errorf("the time %s is not formatted as HH:MM", clock)
+		return
+	}
+	offset = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	return
+}
+
+// Contains indicates the local time is inside the window, the start is inclusive and the end exclusive
+func (window ScheduleWindow) Contains(now time.Time) bool {
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second
+	if window.start <= window.end {
+		return offset >= window.start && offset < window.end
+	}
+	return offset >= window.start || offset < window.end
+}
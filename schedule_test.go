@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleWindowContains(t *testing.T) {
+	schedule, err := ParseSchedule([]string{"09:00-18:00", "22:30-06:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clock    string
+		expected []bool
+	}{
+		{"08:59", []bool{false, false}},
+		{"09:00", []bool{true, false}},
+		{"17:59", []bool{true, false}},
+		{"18:00", []bool{false, false}},
+		{"23:00", []bool{false, true}},
+		{"05:59", []bool{false, true}},
+		{"06:00", []bool{false, false}},
+	}
+
+	for _, test := range tests {
+		now, _ := time.ParseInLocation("15:04", test.clock, time.Local)
+		for i, window := range schedule {
+			if actual := window.Contains(now); actual != test.expected[i] {
+				t.Errorf("window %d contains %s = %v, expected %v", i, test.clock, actual, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, window := range []string{"09:00", "9-18", "09:00-25:00", "09:00-10:00-11:00"} {
+		if _, err := ParseSchedule([]string{window}); err == nil {
+			t.Errorf("%s: expected an error", window)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+// StableCheckInterval is how often the files waiting for their content to settle are checked
+const StableCheckInterval = time.Duration(100) * time.Millisecond
+
+// pendingFile is a file waiting for its content to settle before its event is handled
+type pendingFile struct {
+	evt     *fsnotify.FileEvent
+	rules   []*Rule
+	size    int64
+	hash    string
+	hashed  bool
+	changed time.Time
+}
+
+// deferUntilStable holds the event until the content of the file stops changing, the first event of the file is
+// kept and the following ones only restart the quiet period
+func (w *WatchService) deferUntilStable(evt *fsnotify.FileEvent, rules []*Rule) {
+	pending, found := w.pending[evt.Name]
+	if !found {
+		pending = &pendingFile{evt: evt, rules: rules, size: -1}
+		w.pending[evt.Name] = pending
+	}
+	pending.changed = time.Now()
+	Logf("%s: %s waiting for the content to settle for %s", getEventType(evt), evt.Name, w.config.StableFor)
+}
+
+// checkStableFiles handles the events of the files whose size and hash did not change for the quiet period
+func (w *WatchService) checkStableFiles(now time.Time) {
+	for path, pending := range w.pending {
+		size, err := getFileSize(path)
+		if err != nil {
+			Logln(err)
+			delete(w.pending, path)
+			continue
+		}
+		if size != pending.size {
+			pending.size, pending.hashed, pending.changed = size, false, now
+			continue
+		}
+		if now.Sub(pending.changed) < w.config.StableFor {
+			continue
+		}
+
+		// the hash is only computed once the size settled, large files are not read on every check
+		hash, err := w.hash(path)
+		if err != nil {
+			Logln(err)
+			delete(w.pending, path)
+			continue
+		}
+		if !pending.hashed || hash != pending.hash {
+			pending.hash, pending.hashed, pending.changed = hash, true, now
+			continue
+		}
+
+		delete(w.pending, path)
+		Logf("%s: %s content settled", getEventType(pending.evt), path)
+		runID := w.dispatch(pending.evt, pending.rules)
+		w.recordEvent(pending.evt, true, runID)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestCheckStableFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Commands = StringSet{"true"}
+	config.StableFor = time.Second
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "upload.bin")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectCommands := func(expected uint64, reason string) {
+		if commands := w.Stats().Commands; commands != expected {
+			t.Fatalf("%s: expected %d commands, got %d", reason, expected, commands)
+		}
+	}
+
+	write("part")
+	w.deferUntilStable(&fsnotify.FileEvent{Name: path}, w.rules)
+	w.deferUntilStable(&fsnotify.FileEvent{Name: path}, w.rules)
+	if len(w.pending) != 1 {
+		t.Fatalf("the events of a file should be held once, got %d", len(w.pending))
+	}
+
+	now := time.Now()
+	w.checkStableFiles(now)
+	write("part, more")
+	w.checkStableFiles(now.Add(1500 * time.Millisecond))
+	expectCommands(0, "the size changed")
+	w.checkStableFiles(now.Add(3 * time.Second))
+	expectCommands(0, "the size settled, the hash needs a quiet period too")
+
+	write("PART, MORE")
+	w.checkStableFiles(now.Add(4500 * time.Millisecond))
+	expectCommands(0, "the content changed with the same size")
+	w.checkStableFiles(now.Add(6 * time.Second))
+	expectCommands(1, "the content settled")
+	if len(w.pending) != 0 {
+		t.Errorf("the settled file should not be held anymore, got %d", len(w.pending))
+	}
+	w.checkStableFiles(now.Add(10 * time.Second))
+	expectCommands(1, "the settled file runs the commands once")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StateSaveInterval is how often the state file is saved when events were recorded since it was last saved
+const StateSaveInterval = time.Duration(1) * time.Second
+
+// State is the part of a WatchService persisted to the state file, so that a restart does not reset the interval
+type State struct {
+	LastExec time.Time
+	// RulesExec is the last execution time of each rule, indexed like the rules
+	RulesExec []time.Time   `json:",omitempty"`
+	History   []EventRecord `json:",omitempty"`
+}
+
+// LoadState reads a state file written by saveState
+func LoadState(filename string) (state *State, err error) {
+	rawdata, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	state = &State{}
+	err = json.Unmarshal(rawdata, state)
+	return
+}
+
+// restoreState loads the last execution times and the event history from the state file, a missing, corrupt or future state is ignored
+func (w *WatchService) restoreState() {
+	state, err := LoadState(w.config.StateFile)
+	if err != nil {
+		Logf("cannot load state file: %v", err)
+		return
+	}
+	if state.LastExec.After(time.Now()) {
+		Logf("ignoring state file %s, the last execution time %s is in the future", w.config.StateFile, state.LastExec)
+		return
+	}
+	w.lastExec = state.LastExec
+	Logf("restored last execution time: %s", w.lastExec)
+	for _, rule := range w.rules {
+		// a state file written before the rules had their own interval, or for other rules, only has the last
+		// execution time
+		rule.lastExec = state.LastExec
+		if len(state.RulesExec) == len(w.rules) && !state.RulesExec[rule.index].After(state.LastExec) {
+			rule.lastExec = state.RulesExec[rule.index]
+		}
+	}
+	for _, record := range state.History {
+		w.history.Add(record)
+	}
+}
+
+// saveState writes the state file through a temporary file, so that a crash cannot leave it half-written
+func (w *WatchService) saveState() (err error) {
+	state := &State{LastExec: w.lastExec, RulesExec: make([]time.Time, len(w.rules)), History: w.history.Entries()}
+	for _, rule := range w.rules {
+		state.RulesExec[rule.index] = rule.lastExec
+	}
+	rawdata, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(w.config.StateFile), "."+filepath.Base(w.config.StateFile)+".")
+	if err != nil {
+		return
+	}
+	if err = f.Chmod(os.FileMode(w.config.FileMode)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	if _, err = f.Write(rawdata); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err = os.Rename(f.Name(), w.config.StateFile); err != nil {
+		os.Remove(f.Name())
+	}
+	return
+}
+
+// flushState saves the state file, an error is only logged
+func (w *WatchService) flushState() {
+	w.stateDirty = false
+	if err := w.saveState(); err != nil {
+		log.Println("cannot save state file:", err)
+	}
+}
+
+// isStateFile indicates the path is the state file or one of its temporary files, their changes are not events
+func (w *WatchService) isStateFile(path string) bool {
+	if w.config.StateFile == "" {
+		return false
+	}
+	path = filepath.Clean(path)
+	stateFile := filepath.Clean(w.config.StateFile)
+	return path == stateFile ||
+		(filepath.Dir(path) == filepath.Dir(stateFile) && strings.HasPrefix(filepath.Base(path), "."+filepath.Base(stateFile)+"."))
+}
+
+// isReadyFile indicates the path is the ready file of the commands, its changes are not events
+func (w *WatchService) isReadyFile(path string) bool {
+	return w.config.ReadyFile != "" && filepath.Clean(path) == filepath.Clean(w.config.ReadyFile)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestStateFileEventsNotRecorded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.StateFile = filepath.Join(dir, "state.json")
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.processEvent(&fsnotify.FileEvent{Name: config.StateFile})
+	w.processEvent(&fsnotify.FileEvent{Name: filepath.Join(dir, ".state.json.123")})
+	if history := w.History(); len(history) != 0 {
+		t.Fatalf("the writes of the state file should not be recorded, got %v", history)
+	}
+
+	w.processEvent(&fsnotify.FileEvent{Name: filepath.Join(dir, "main.go")})
+	if history := w.History(); len(history) != 1 {
+		t.Fatalf("expected the event of main.go in the history, got %v", history)
+	}
+	if _, err := os.Stat(config.StateFile); !os.IsNotExist(err) {
+		t.Errorf("the state file should not be written on each event, got %v", err)
+	}
+
+	w.flushState()
+	state, err := LoadState(config.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.History) != 1 {
+		t.Errorf("expected the event of main.go in the state file, got %v", state.History)
+	}
+}
+
+func TestStateKeepsRuleIntervals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.StateFile = filepath.Join(dir, "state.json")
+	config.Rules = []RuleConfig{
+		{Pattern: "\\.go$", Commands: StringSet{"true"}, Interval: time.Hour},
+		{Pattern: "\\.md$", Commands: StringSet{"true"}, Interval: time.Hour},
+	}
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.dispatch(&fsnotify.FileEvent{Name: filepath.Join(dir, "main.go")}, w.rules[:1])
+	if commands := w.Stats().Commands; commands != 1 {
+		t.Fatalf("expected the commands to run, got %d commands", commands)
+	}
+	w.flushState()
+
+	if w, err = NewWatchService(dir, &config); err != nil {
+		t.Fatal(err)
+	}
+	w.restoreState()
+	tests := []struct {
+		name     string
+		rule     int
+		commands uint64
+	}{
+		{"main.go", 0, 0},
+		{"README.md", 1, 1},
+	}
+	for _, test := range tests {
+		w.dispatch(&fsnotify.FileEvent{Name: filepath.Join(dir, test.name)}, w.rules[test.rule:test.rule+1])
+		if commands := w.Stats().Commands; commands != test.commands {
+			t.Errorf("%s: expected %d commands after the restart, got %d", test.name, test.commands, commands)
+		}
+	}
+}
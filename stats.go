@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Stats holds counters about the events handled by a WatchService
+type Stats struct {
+	// DroppedEvents is the number of matching events dropped by the interval limiter
+	DroppedEvents uint64
+	// LastDropped is the name of the file of the last dropped event
+	LastDropped string
+	// OverflowEvents is the number of events dropped because the event buffer was full
+	OverflowEvents uint64
+	// DuplicateEvents is the number of events skipped because an identical event was queued (-dedup)
+	DuplicateEvents uint64
+	// PendingEvents is the number of qualifying events counted towards the count threshold since the last run
+	PendingEvents int
+
+	// Events is the number of events processed by the worker
+	Events uint64
+	// Commands is the number of commands executed
+	Commands uint64
+	// Failures is the number of commands that failed
+	Failures uint64
+	// CommandTime is the total running time of the commands
+	CommandTime time.Duration
+}
+
+// Summary is the JSON representation of the counters printed on shutdown
+type Summary struct {
+	Events      uint64  `json:"events"`
+	Commands    uint64  `json:"commands"`
+	Failures    uint64  `json:"failures"`
+	CommandTime float64 `json:"command_time_seconds"`
+}
+
+// Stats returns a snapshot of the counters
+func (w *WatchService) Stats() Stats {
+	w.statsLock.Lock()
+	defer w.statsLock.Unlock()
+	return w.stats
+}
+
+// History returns the last processed events, the oldest first
+func (w *WatchService) History() []EventRecord {
+	return w.history.Entries()
+}
+
+func (w *WatchService) updateStats(update func(stats *Stats)) {
+	w.statsLock.Lock()
+	defer w.statsLock.Unlock()
+	update(&w.stats)
+}
+
+// printSummary logs the counters of the run, as a JSON object when the log format is json
+func (w *WatchService) printSummary() {
+	stats := w.Stats()
+	if w.config.LogFormat == LogFormatJSON {
+		summary := &Summary{stats.Events, stats.Commands, stats.Failures, stats.CommandTime.Seconds()}
+		if err := json.NewEncoder(log.Writer()).Encode(summary); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	log.Printf("summary: %d events, %d commands, %d failed, command time %s", stats.Events, stats.Commands, stats.Failures, stats.CommandTime)
+}
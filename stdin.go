@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readPaths sends the paths read from the reader, one per line, to the worker until the reader is exhausted, the
+// paths already watched stay watched
+func (w *WatchService) readPaths(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" {
+			continue
+		}
+		select {
+		case w.stdinPaths <- path:
+		case <-w.done:
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("cannot read the paths from stdin:", err)
+		return
+	}
+	Logln("no more paths on stdin")
+}
+
+// watchStdin watches the paths piped to stdin as they arrive
+func (w *WatchService) watchStdin() {
+	w.manifest = make(map[string]bool)
+	go w.readPaths(os.Stdin)
+}
+
+// addPath watches a directory, or the parent directory of a file, and lists it in the manifest
+func (w *WatchService) addPath(path string) {
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	dir := path
+	if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	w.manifest[path] = true
+	if w.isDir(dir) {
+		return
+	}
+	Logln("watching: ", dir)
+	if err := w.watcher.Watch(dir); err != nil {
+		log.Println(explainWatchError(err))
+		return
+	}
+	w.addDir(dir)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pinterb/watchf/daemon"
+)
+
+const (
+	// StatusTimeFormat is the layout of the times printed by the status command
+	StatusTimeFormat = "2006-01-02 15:04:05"
+	// RestartStopTimeout is how long the restart command waits for the running daemon to exit
+	RestartStopTimeout = time.Duration(30) * time.Second
+)
+
+// Subcommand is an action run instead of watching, e.g. "watchf logs"
+type Subcommand struct {
+	Name string
+	Desc string
+	Run  func(args []string) error
+}
+
+var subcommands = []Subcommand{
+	{Name: "bench", Desc: "Write files in a temporary directory at -rate per second for -duration with the given options and print the latencies as JSON", Run: runBench},
+	{Name: "logs", Desc: "Follow the log file of the running " + Program, Run: runLogs},
+	{Name: "restart", Desc: "Stop the running " + Program + ", wait for it to exit and watch with the given options", Run: runRestart},
+	{Name: "status", Desc: "Print whether " + Program + " is running and its last processed events (needs -state-file)", Run: runStatus},
+	{Name: "tree", Desc: "Print the directories a watch would watch, and the skipped ones with the reason", Run: runTree},
+}
+
+func runSubcommand(name string, args []string) {
+	for _, subcommand := range subcommands {
+		if subcommand.Name == name {
+			if err := subcommand.Run(args); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(-1)
+			}
+			return
+		}
+	}
+
+	flag.Usage()
+	os.Exit(-1)
+}
+
+func runLogs(args []string) error {
+	config := resolveConfig()
+	if config.LogFile == "" {
+		return fmt.Errorf("no log file is configured, start %s with -log-file to write one", Program)
+	}
+	return followFile(config.LogFile, os.Stdout, LogsTailLines)
+}
+
+// runTree walks the watch path like watchFolders without starting the watcher
+func runTree(args []string) (err error) {
+	config := resolveConfig()
+	_, path, err := parseWatchTarget(config.Target)
+	if err != nil {
+		return
+	}
+	w := &WatchService{path: path, config: config}
+	if config.FilterFile != "" {
+		if w.filters, err = LoadFilterRules(config.FilterFile, config.FilterDefault); err != nil {
+			return
+		}
+	}
+	if w.ignore, err = LoadIgnoreFile(filepath.Join(path, IgnoreFile)); err != nil {
+		return
+	}
+
+	if !config.Recursive {
+		fmt.Println(w.path)
+		return
+	}
+	for _, root := range w.treeRoots() {
+		err = w.walkTree(root, func(path string) error {
+			fmt.Println(path)
+			return nil
+		}, func(path string, reason string, _ error) {
+			fmt.Printf("%s (skipped, %s)\n", path, reason)
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// runStatus prints the state of the daemon and the event history kept in the state file
+func runStatus(args []string) error {
+	config := resolveConfig()
+	if config.StateFile == "" {
+		return fmt.Errorf("no state file is configured, start %s with -state-file to keep its status", Program)
+	}
+
+	dmon := daemon.NewDaemon(Program, nil)
+	if dmon.IsRunning() {
+		fmt.Printf("%s is running, pid %d\n", Program, dmon.GetPid())
+	} else {
+		fmt.Printf("%s is not running\n", Program)
+	}
+
+	state, err := LoadState(config.StateFile)
+	if err != nil {
+		return err
+	}
+	if !state.LastExec.IsZero() {
+		fmt.Println("last run:", state.LastExec.Format(StatusTimeFormat))
+	}
+	for _, record := range state.History {
+		var result string
+		switch {
+		case record.Executed && record.ExitCode != 0:
+			result = fmt.Sprintf("run %s, failed with exit code %d", record.RunID, record.ExitCode)
+		case record.Executed:
+			result = fmt.Sprintf("run %s", record.RunID)
+		case record.Matched:
+			result = "matched, not run"
+		default:
+			result = "not matched"
+		}
+		fmt.Printf("%s %s: %s %s\n", record.Time.Format(StatusTimeFormat), record.Event, record.Path, result)
+	}
+	return nil
+}
+
+// runRestart stops the running daemon and then watches in this process, the configuration is loaded first so that
+// an invalid one does not stop the running daemon
+func runRestart(args []string) error {
+	sig, err := daemon.ParseSignal(stopSignal)
+	if err != nil {
+		return err
+	}
+	config := loadConfig()
+
+	dmon := daemon.NewDaemon(Program, nil)
+	dmon.StopSignal = sig
+	dmon.Token = stopToken
+	if !dmon.IsRunning() {
+		fmt.Printf("%s is not running, starting it\n", Program)
+	} else {
+		fmt.Printf("stopping process:%d\n", dmon.GetPid())
+		// Stop reports the process still running right after the signal, WaitStopped waits for it to exit
+		if err = dmon.Stop(); errors.Is(err, daemon.ErrTokenMismatch) {
+			return err
+		}
+		if err = dmon.WaitStopped(RestartStopTimeout); err != nil {
+			return err
+		}
+		fmt.Printf("process:%d stopped, starting %s\n", dmon.GetPid(), Program)
+	}
+
+	runWatch(config, sig)
+	return nil
+}
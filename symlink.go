@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+const (
+	// SymlinkCheckInterval is how often the targets of the symlinks in the watched directories are checked
+	SymlinkCheckInterval = time.Duration(1) * time.Second
+	// RetargetEventType is the event type (%t) of a symlink pointing to a new target
+	RetargetEventType = "ENTRY_RETARGET"
+)
+
+// scanSymlinks reads the targets of the symlinks in the watched directories and returns the retargeted symlinks,
+// the first scan only fills the cache
+func (w *WatchService) scanSymlinks() (retargeted []string) {
+	dirs := w.WatchedDirs()
+	if len(dirs) == 0 {
+		dirs = []string{w.path}
+	}
+
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			Logln(err)
+			continue
+		}
+		for _, info := range infos {
+			if info.Mode()&os.ModeSymlink == 0 {
+				continue
+			}
+			path := filepath.Join(dir, info.Name())
+			target, err := os.Readlink(path)
+			if err != nil {
+				Logln(err)
+				continue
+			}
+			seen[path] = true
+
+			cachedTarget, found := w.links[path]
+			if found && cachedTarget != target {
+				Logf("symlink %s, target: %s > %s", path, cachedTarget, target)
+				retargeted = append(retargeted, path)
+			}
+			w.links[path] = target
+		}
+	}
+
+	for path := range w.links {
+		if !seen[path] {
+			delete(w.links, path)
+		}
+	}
+	return
+}
+
+// handleRetarget runs the commands of the rules matching the symlink and watching modify events, fsnotify does not
+// report the retargeting so it is treated as a modify of the symlink, filtered and dispatched like the other events
+func (w *WatchService) handleRetarget(path string) {
+	evt := &fsnotify.FileEvent{Name: path}
+	if w.config.TailFile != "" || !w.passesFilters(evt) {
+		return
+	}
+
+	var rules []*Rule
+	for _, candidate := range w.rules {
+		if _, found := candidate.watchFlags[ModifyEvent.Name]; found && checkPatternMatching(candidate.pattern, w.relativeToRoot(path)) {
+			rules = append(rules, candidate)
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	log.Printf("symlink %s now points to %s", path, w.links[path])
+	w.dispatchTrigger(&Trigger{Event: evt, Rule: rules[0], Symlink: w.links[path]}, rules)
+}
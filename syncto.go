@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// syncToDestination mirrors the changed file into the SyncTo directory, keeping its path relative to the watch root
+func (w *WatchService) syncToDestination(trigger *Trigger) {
+	evt := trigger.Event
+	dst, inside := syncDestination(w.config.SyncTo, w.relativeToRoot(evt.Name))
+	if !inside {
+		log.Printf("sync %s skipped, %s is not inside %s", evt.Name, dst, w.config.SyncTo)
+		return
+	}
+
+	var err error
+	switch {
+	case evt.IsDelete() || evt.IsRename():
+		if !w.config.SyncDelete {
+			return
+		}
+		Logf("sync: remove %s", dst)
+		err = os.RemoveAll(dst)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	case trigger.Dir:
+		Logf("sync: mkdir %s", dst)
+		err = os.MkdirAll(dst, 0755)
+	default:
+		Logf("sync: copy %s to %s", evt.Name, dst)
+		err = copyFile(evt.Name, dst)
+	}
+
+	if err != nil {
+		log.Printf("sync %s failed, caused by: %s\n", evt.Name, err)
+	}
+}
+
+// syncDestination returns the path of the relative path in the SyncTo directory, inside is false when the path
+// escapes the directory (e.g. a path outside of the watch root) or is the directory itself
+func syncDestination(syncTo string, relative string) (dst string, inside bool) {
+	root := filepath.Clean(syncTo)
+	dst = filepath.Join(root, relative)
+	rel, err := filepath.Rel(root, dst)
+	inside = err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+	return
+}
+
+// inSyncDestination indicates the path is inside the SyncTo directory, events there are caused by the sync itself
+func (w *WatchService) inSyncDestination(path string) bool {
+	if w.config.SyncTo == "" {
+		return false
+	}
+	rel, err := filepath.Rel(w.config.SyncTo, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// copyFile copies src to dst through a temporary file, so that dst is never seen half-written
+func copyFile(src string, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".")
+	if err != nil {
+		return
+	}
+	defer os.Remove(out.Name())
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return
+	}
+	if err = out.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(out.Name(), stat.Mode().Perm()); err != nil {
+		return
+	}
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncDestination(t *testing.T) {
+	tests := []struct {
+		relative string
+		inside   bool
+	}{
+		{"src/main.go", true},
+		{"../outside/main.go", false},
+		{"..", false},
+		{"src/../../main.go", false},
+		{".", false},
+		{"..data/main.go", true},
+	}
+	for _, test := range tests {
+		dst, inside := syncDestination("backup/", test.relative)
+		if inside != test.inside {
+			t.Errorf("syncDestination(%q) = %s, %v, expected %v", test.relative, dst, inside, test.inside)
+		}
+	}
+	if dst, _ := syncDestination("backup/", "src/main.go"); dst != filepath.Join("backup", "src", "main.go") {
+		t.Errorf("unexpected destination %s", dst)
+	}
+}
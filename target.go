@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+)
+
+// WatchBackendFsnotify is the scheme of the fsnotify backend, the backend of the bare paths
+const WatchBackendFsnotify = "file"
+
+// parseWatchTarget splits a -target such as file://./src into the scheme of its backend and the path to watch, a
+// bare path uses the fsnotify backend and an empty target is the working directory
+func parseWatchTarget(target string) (scheme string, path string, err error) {
+	scheme, path = WatchBackendFsnotify, target
+	if i := strings.Index(target, "://"); i > 0 {
+		scheme, path = strings.ToLower(target[:i]), target[i+len("://"):]
+	}
+	if path == "" {
+		path = "."
+	}
+
+	// fsnotify is the only backend, a polling backend would have to report events the fsnotify types cannot hold
+	if scheme != WatchBackendFsnotify {
+		err = &OptionError{"watch backend", scheme}
+	}
+	return
+}
+
+// NewTargetService creates the service of the backend selected by the scheme of the -target
+func NewTargetService(config *Config) (service *WatchService, err error) {
+	scheme, path, err := parseWatchTarget(config.Target)
+	if err != nil {
+		return
+	}
+	switch scheme {
+	case WatchBackendFsnotify:
+		service, err = NewWatchService(path, config)
+	}
+	return
+}
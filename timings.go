@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// TimingWaitClose is the name of the timing of the waits for the files to be closed
+	TimingWaitClose = "wait for the file to be closed"
+	// TimingHash is the name of the timing of the hashing of the file contents
+	TimingHash = "hash the file content"
+)
+
+// CheckTiming accumulates the durations of a filter check
+type CheckTiming struct {
+	Name  string
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Average returns the mean duration of the check
+func (c *CheckTiming) Average() time.Duration {
+	if c.Count == 0 {
+		return 0
+	}
+	return c.Total / time.Duration(c.Count)
+}
+
+// TimingSummary is the JSON representation of a CheckTiming
+type TimingSummary struct {
+	Check   string  `json:"check"`
+	Count   uint64  `json:"count"`
+	Average float64 `json:"average_seconds"`
+	Max     float64 `json:"max_seconds"`
+}
+
+// Timings accumulates the durations of the filter checks measured by decorator, of the waits for the files to be
+// closed and of the hashing, once enabled by -timings
+type Timings struct {
+	lock    sync.Mutex
+	enabled bool
+	checks  map[string]*CheckTiming
+}
+
+// filterTimings is shared by decorator and the services, the checks are plain functions
+var filterTimings = &Timings{checks: make(map[string]*CheckTiming)}
+
+// Enable starts accumulating the durations
+func (t *Timings) Enable() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.enabled = true
+}
+
+// Add accumulates a duration of the named check, nothing is kept until the timings are enabled
+func (t *Timings) Add(name string, duration time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.enabled {
+		return
+	}
+	check, found := t.checks[name]
+	if !found {
+		check = &CheckTiming{Name: name}
+		t.checks[name] = check
+	}
+	check.Count++
+	check.Total += duration
+	if duration > check.Max {
+		check.Max = duration
+	}
+}
+
+// Snapshot returns a copy of the accumulated timings, the longest total first
+func (t *Timings) Snapshot() (checks []CheckTiming) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, check := range t.checks {
+		checks = append(checks, *check)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		if checks[i].Total != checks[j].Total {
+			return checks[i].Total > checks[j].Total
+		}
+		return checks[i].Name < checks[j].Name
+	})
+	return
+}
+
+// timedHasher accumulates the durations of the hasher under TimingHash, a nil hasher uses adler32
+func timedHasher(hash Hasher) Hasher {
+	if hash == nil {
+		hash = getContentHash
+	}
+	return func(path string) (string, error) {
+		startTime := time.Now()
+		defer func() {
+			filterTimings.Add(TimingHash, time.Since(startTime))
+		}()
+		return hash(path)
+	}
+}
+
+// timedWaiter accumulates the durations of the close waiter under TimingWaitClose, a nil waiter does not wait
+func timedWaiter(waitClose func(path string) error) func(path string) error {
+	if waitClose == nil {
+		return nil
+	}
+	return func(path string) error {
+		startTime := time.Now()
+		defer func() {
+			filterTimings.Add(TimingWaitClose, time.Since(startTime))
+		}()
+		return waitClose(path)
+	}
+}
+
+// startTimingsLogger logs the accumulated timings every -timings until the service is stopped
+func (w *WatchService) startTimingsLogger() {
+	go func() {
+		ticker := time.NewTicker(w.config.Timings)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.logTimings()
+			}
+		}
+	}()
+}
+
+// logTimings logs the count, average and maximum duration of each check, as JSON objects when the log format is json
+func (w *WatchService) logTimings() {
+	for _, check := range filterTimings.Snapshot() {
+		if w.config.LogFormat == LogFormatJSON {
+			summary := &TimingSummary{check.Name, check.Count, check.Average().Seconds(), check.Max.Seconds()}
+			if err := json.NewEncoder(log.Writer()).Encode(summary); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		log.Printf("timing: %s: %d calls, average %s, max %s", check.Name, check.Count, check.Average(), check.Max)
+	}
+}
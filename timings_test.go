@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimings(t *testing.T) {
+	timings := &Timings{checks: make(map[string]*CheckTiming)}
+	timings.Add(TimingHash, time.Second)
+	if checks := timings.Snapshot(); len(checks) != 0 {
+		t.Fatalf("nothing should be kept until the timings are enabled, got %v", checks)
+	}
+
+	timings.Enable()
+	timings.Add(TimingHash, 3*time.Millisecond)
+	timings.Add(TimingHash, time.Millisecond)
+	timings.Add(TimingWaitClose, 20*time.Millisecond)
+
+	checks := timings.Snapshot()
+	if len(checks) != 2 || checks[0].Name != TimingWaitClose {
+		t.Fatalf("expected the longest total first, got %v", checks)
+	}
+	if hash := checks[1]; hash.Count != 2 || hash.Average() != 2*time.Millisecond || hash.Max != 3*time.Millisecond {
+		t.Errorf("expected 2 calls, an average of 2ms and a max of 3ms, got %d calls, %s and %s", hash.Count, hash.Average(), hash.Max)
+	}
+}
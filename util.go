@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"io"
 	"log"
 	"strings"
 )
@@ -26,3 +28,53 @@ func Logf(format string, args ...interface{}) {
 		log.Printf(format, args...)
 	}
 }
+
+// Traceln is a log.Println wrapper that only writes to log when the trace flag is set, used for the filter checks
+func Traceln(v ...interface{}) {
+	if trace {
+		log.Println(v...)
+	}
+}
+
+// Tracef is a log.Printf wrapper that only writes to log when the trace flag is set, used for the filter checks
+func Tracef(format string, args ...interface{}) {
+	if trace {
+		log.Printf(format, args...)
+	}
+}
+
+// PrefixWriter is an io.Writer that writes Prefix at the start of every line
+type PrefixWriter struct {
+	Writer io.Writer
+	Prefix string
+
+	midLine bool
+}
+
+// Write writes p to the underlying writer, prefixing each new line
+func (pw *PrefixWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if !pw.midLine {
+			if _, err = io.WriteString(pw.Writer, pw.Prefix); err != nil {
+				return
+			}
+		}
+
+		line := p
+		if idx := bytes.IndexByte(p, '\n'); idx >= 0 {
+			line = p[:idx+1]
+			pw.midLine = false
+		} else {
+			pw.midLine = true
+		}
+
+		var written int
+		written, err = pw.Writer.Write(line)
+		n += written
+		if err != nil {
+			return
+		}
+		p = p[len(line):]
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		writes   []string
+		expected string
+	}{
+		{[]string{"one\ntwo\n"}, "[7] one\n[7] two\n"},
+		{[]string{"par", "tial\n", "next"}, "[7] partial\n[7] next"},
+		{[]string{"\n\n"}, "[7] \n[7] \n"},
+		{[]string{""}, ""},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		pw := &PrefixWriter{Writer: &buf, Prefix: "[7] "}
+		for _, write := range test.writes {
+			if n, err := pw.Write([]byte(write)); err != nil || n != len(write) {
+				t.Fatalf("%q: expected %d bytes written, got %d, %v", write, len(write), n, err)
+			}
+		}
+		if actual := buf.String(); actual != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.writes, test.expected, actual)
+		}
+	}
+}
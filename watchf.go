@@ -3,10 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/pinterb/watchf/daemon"
 )
@@ -16,31 +19,63 @@ const (
 	Version         = "0.4.2"
 	Program         = "watchf"
 	ContinueOnError = false
+	// TokenEnv is the environment variable holding the stop token when neither -token nor -token-file is given
+	TokenEnv = "WATCHF_TOKEN"
+)
+
+// The build information, injected at build time, e.g. go build -ldflags "-X main.GitCommit=$(git rev-parse HEAD)"
+var (
+	GitCommit string
+	BuildDate string
 )
 
 var (
 	verbose     bool
+	trace       bool
 	showVersion bool
 	stop        bool
+	stopSignal  string
+	stopToken   string
+	tokenFile   string
 	configFile  string
+	profile     string
 	writeConfig bool
+	toFlags     bool
+	dryRun      bool
+
+	// programFlags are the flags of the program itself, the other flags set the Config
+	programFlags = map[string]bool{"V": true, "trace": true, "v": true, "version": true, "s": true, "stop-signal": true,
+		"token": true, "token-file": true, "f": true, "profile": true, "w": true, "to-flags": true, "dry-run": true}
 
 	quit = make(chan os.Signal, 1)
 )
 
 func init() {
 	flag.BoolVar(&verbose, "V", false, "Show debugging messages")
-	flag.BoolVar(&showVersion, "v", false, "Show version and exit")
-	flag.BoolVar(&stop, "s", false, "Stop the "+Program+" Daemon (windows is not support)")
+	flag.BoolVar(&trace, "trace", false, "Show each filter check of the events and its timing (very noisy)")
+	flag.BoolVar(&showVersion, "v", false, "Show version and build information and exit")
+	flag.BoolVar(&showVersion, "version", false, "Show version and build information and exit")
+	flag.BoolVar(&stop, "s", false, "Stop the "+Program+" Daemon (windows sets a stop event, or terminates it with -stop-signal=KILL)")
+	flag.StringVar(&stopSignal, "stop-signal", "INT", "The signal sent by -s and handled as a stop request, e.g. TERM (windows only has INT, TERM and KILL)")
+	flag.StringVar(&stopToken, "token", "", "Require this token from -s to stop the daemon (only a hash is kept in the pid file), visible to the other local users, prefer -token-file or $"+TokenEnv)
+	flag.StringVar(&tokenFile, "token-file", "", "Read the -token from the first line of this file")
 	flag.StringVar(&configFile, "f", "."+Program+".conf", "Specifies a configuration file")
+	flag.StringVar(&profile, "profile", "", "Use a named profile of the configuration file (the top-level options are the default profile)")
 	flag.BoolVar(&writeConfig, "w", false, "Write command-line arguments to configuration file (write and exit)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Validate the configuration, run the filters against the existing files and print how many files each rule would run its commands for (check and exit)")
+	flag.BoolVar(&toFlags, "to-flags", false, "Print the command line reproducing the resolved configuration (print and exit)")
 
 	flag.Usage = func() {
 		command := os.Args[0]
-		fmt.Println("Usage:\n  " + command + " [options]")
+		fmt.Println("Usage:\n  " + command + " [options] [command]")
 		fmt.Println("Options:")
 		flag.PrintDefaults()
 
+		fmt.Println("Commands:")
+		for _, subcommand := range subcommands {
+			fmt.Printf("  %s  %s\n", subcommand.Name, subcommand.Desc)
+		}
+
 		maxLen := maxLenOfEventName()
 		fmt.Println("Events:")
 		for _, eventBit := range ValidEvents {
@@ -49,8 +84,16 @@ func init() {
 
 		fmt.Printf("Variables:\n"+
 			"  %s: The filename of changed file\n"+
-			"  %s: The event type of file changes\n",
-			VarFilename, VarEventType)
+			"  %s: The event type of file changes\n"+
+			"  %s: The attribute changes of attrib events (e.g. mode:-rw-r--r-->-rw-------, also in $WATCHF_OLD_MODE and $WATCHF_NEW_MODE, likewise UID, GID and SIZE; \"new\" when the file was not seen before, with no variables)\n"+
+			"  %s: The path of a snapshot of the changed file (with -snapshot)\n"+
+			"  %s: The appended line matching -grep, the last one unless -grep-each is set\n"+
+			"  %s: The files of the run (the events collapsed by -latest-wins), quoted for a shell prefix such as sh:\n"+
+			"  %s: The path of a temporary file listing the files of the run, NUL-terminated for xargs -0\n"+
+			"  %s, %s: The number of lines added to and removed from a modified text file (with -diff-summary)\n"+
+			"  %s: The failed command (in the -on-failure commands)\n"+
+			"  %s: The exit code of the failed command, -1 when it did not exit (in the -on-failure commands)\n",
+			VarFilename, VarEventType, VarAttrib, VarSnapshot, VarLine, VarFiles, VarFileList, VarLinesAdded, VarLinesRemoved, VarFailedCommand, VarExitCode)
 
 		printExample()
 	}
@@ -69,41 +112,111 @@ func maxLenOfEventName() int {
 func main() {
 	flag.Parse()
 
+	if showVersion {
+		printVersion()
+		return
+	}
+
+	sig, err := daemon.ParseSignal(stopSignal)
+	checkError(err)
+	checkError(resolveStopToken())
+
 	// stop daemon via signal
 	if stop {
-		stopDaemon()
+		stopDaemon(sig)
+		return
+	}
+
+	if flag.NArg() > 0 {
+		runSubcommand(flag.Arg(0), flag.Args()[1:])
 		return
 	}
 
 	config := loadConfig()
-	dmon := startDaemon(config)
+	if dryRun {
+		checkError(runDryPass(config))
+		return
+	}
+	runWatch(config, sig)
+}
+
+// runWatch starts the daemon and watches until it is stopped
+func runWatch(config *Config, sig os.Signal) {
+	redirectLog(config)
+	service, dmon := startDaemon(config)
+	handlePauseSignal(service)
+	handleRunSignal(service)
+
+	err := waitForStop(dmon, service, config, sig)
+	checkError(err)
+	if (config.Duration > 0 || config.MaxRuns > 0) && service.Stats().Failures > 0 {
+		os.Exit(1)
+	}
+}
 
-	waitForStop(dmon)
+func printVersion() {
+	fmt.Println("version:", Version)
+	fmt.Println("go version:", runtime.Version())
+	fmt.Println("os/arch:", runtime.GOOS+"/"+runtime.GOARCH)
+	if GitCommit != "" {
+		fmt.Println("git commit:", GitCommit)
+	}
+	if BuildDate != "" {
+		fmt.Println("build date:", BuildDate)
+	}
 }
 
-func stopDaemon() {
+func stopDaemon(sig os.Signal) {
 	dmon := daemon.NewDaemon(Program, nil)
+	dmon.StopSignal = sig
+	dmon.Token = stopToken
 	if err := dmon.Stop(); err != nil {
 		fmt.Printf("cannot stop process:%d caused by:\n%s\n", dmon.GetPid(), err)
 		os.Exit(-1)
 	}
 }
 
-func loadConfig() (config *Config) {
-	config = GetDefaultConfig()
-
-	if showVersion {
-		fmt.Println("version:", Version)
-		os.Exit(0)
+// resolveStopToken reads the stop token from the -token-file or the environment when -token is not given, the
+// command-line arguments of a process are readable by the other local users
+func resolveStopToken() error {
+	switch {
+	case stopToken != "":
+	case tokenFile != "":
+		rawdata, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return fmt.Errorf("cannot read the token file: %w", err)
+		}
+		stopToken = strings.TrimSpace(strings.SplitN(string(rawdata), "\n", 2)[0])
+		if stopToken == "" {
+			return fmt.Errorf("the token file %s is empty", tokenFile)
+		}
+	default:
+		stopToken = os.Getenv(TokenEnv)
 	}
+	return nil
+}
 
-	if flag.NArg() > 0 {
-		flag.Usage()
-		os.Exit(0)
+// redactToken hides the value of -token in the command-line arguments
+func redactToken(args []string) (redacted []string) {
+	redacted = append(redacted, args...)
+	for i, arg := range redacted {
+		switch {
+		case arg == "-token" || arg == "--token":
+			if i+1 < len(redacted) {
+				redacted[i+1] = "***"
+			}
+		case strings.HasPrefix(arg, "-token=") || strings.HasPrefix(arg, "--token="):
+			redacted[i] = arg[:strings.Index(arg, "=")+1] + "***"
+		}
 	}
+	return
+}
+
+func loadConfig() (config *Config) {
+	config = GetDefaultConfig()
 
 	Logln("version:", Version)
-	Logln("command-line arguments:", os.Args[1:])
+	Logln("command-line arguments:", redactToken(os.Args[1:]))
 
 	if writeConfig {
 		if err := WriteConfigToFile(config); err != nil {
@@ -114,32 +227,126 @@ func loadConfig() (config *Config) {
 		}
 	}
 
-	if flag.NFlag() == 0 || (flag.NFlag() == 1 && verbose) {
+	config = resolveConfig()
+	if toFlags {
+		printFlags(config)
+		os.Exit(0)
+	}
+	if config.WatchStdin && config.CommandsFile == "-" {
+		log.Fatal("stdin cannot provide both the paths (-stdin) and the commands (-commands-file=-)")
+	}
+	checkError(loadCommandsFile(config))
+	Logf("configuration: %+v", config)
+
+	if len(config.Commands) == 0 && len(config.DeleteCommands) == 0 && len(config.Rules) == 0 && config.SyncTo == "" && !stop {
+		flag.Usage()
+		os.Exit(-1)
+	}
+
+	return
+}
+
+// loadCommandsFile appends the commands of the -commands-file to the commands
+func loadCommandsFile(config *Config) error {
+	if config.CommandsFile == "" {
+		return nil
+	}
+	commands, err := LoadCommandsFile(config.CommandsFile)
+	if err != nil {
+		return err
+	}
+	config.Commands = append(config.Commands, commands...)
+	return nil
+}
+
+// resolveConfig returns the configuration file when only -V, -trace, -f, -profile, -token, -token-file, -to-flags and -dry-run were given, otherwise the command-line arguments
+func resolveConfig() (config *Config) {
+	config = GetDefaultConfig()
+
+	useFile := true
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "V" && f.Name != "trace" && f.Name != "f" && f.Name != "profile" && f.Name != "token" && f.Name != "token-file" && f.Name != "to-flags" && f.Name != "dry-run" {
+			useFile = false
+		}
+	})
+	if useFile {
 		if newConfig, err := LoadConfigFromFile(); err != nil {
+			if profile != "" {
+				log.Fatalf("cannot load the profile %s: %v", profile, err)
+			}
 			Logf("cannot load configuration file: %v", err)
 		} else {
 			config = newConfig
 		}
 	}
-	Logf("configuration: %+v", config)
+	return
+}
 
-	if len(config.Commands) == 0 && !stop {
-		flag.Usage()
-		os.Exit(-1)
+// printFlags prints the command line reproducing the config, the options without a flag are reported on stderr
+func printFlags(config *Config) {
+	args, omitted := ConfigFlags(config)
+	fmt.Println(strings.Join(append([]string{Program}, args...), " "))
+	if len(omitted) > 0 {
+		fmt.Fprintf(os.Stderr, "the options %s have no flag and were left out\n", strings.Join(omitted, ", "))
 	}
+}
 
-	return
+// redirectLog sends the log to the configured log file
+func redirectLog(config *Config) {
+	if config.LogFile == "" {
+		return
+	}
+	f, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.FileMode(config.FileMode))
+	checkError(err)
+	log.SetOutput(f)
 }
 
-func startDaemon(config *Config) *daemon.Daemon {
-	service, err := NewWatchService(".", config)
+func startDaemon(config *Config) (*ReloadingService, *daemon.Daemon) {
+	service, err := NewReloadingService(config)
 	checkError(err)
 
 	dmon := daemon.NewDaemon(Program, service)
+	dmon.Token = stopToken
+	dmon.FileMode = os.FileMode(config.FileMode)
 	err = dmon.Start()
 	checkError(err)
+	checkError(dmon.NotifyStop(quit))
+
+	return service, dmon
+}
+
+// handlePauseSignal toggles pausing the commands on the pause signal (SIGUSR1, not supported on windows)
+func handlePauseSignal(service *ReloadingService) {
+	pause := make(chan os.Signal, 1)
+	if !notifyPause(pause) {
+		return
+	}
+
+	go func() {
+		for range pause {
+			if service.Paused() {
+				service.Resume()
+				log.Println(Program + " resumed")
+			} else {
+				service.Pause()
+				log.Println(Program + " paused")
+			}
+		}
+	}()
+}
+
+// handleRunSignal runs the commands on the run signal (SIGUSR2, not supported on windows)
+func handleRunSignal(service *ReloadingService) {
+	run := make(chan os.Signal, 1)
+	if !notifyRun(run) {
+		return
+	}
 
-	return dmon
+	go func() {
+		for range run {
+			service.RunNow()
+		}
+	}()
 }
 
 func checkError(err error) {
@@ -148,13 +355,48 @@ func checkError(err error) {
 	}
 }
 
-func waitForStop(daemon *daemon.Daemon) {
-	signal.Notify(quit, os.Kill, os.Interrupt)
+// waitForStop stops the daemon on a signal, a watcher error with -exit-on-error, once the -duration elapsed,
+// the -max-runs were run or a failed reload left nothing running, whose error is returned
+func waitForStop(daemon *daemon.Daemon, service *ReloadingService, config *Config, stopSignal os.Signal) (err error) {
+	signal.Notify(quit, os.Kill, os.Interrupt, stopSignal)
 
-	<-quit
-	if err := daemon.Stop(); err != nil {
-		fmt.Printf(Program+" stop failed: %s\n", err)
+	var deadline <-chan time.Time
+	if config.Duration > 0 {
+		deadline = time.After(config.Duration)
+	}
+
+	watchErrors := service.Errors()
+wait:
+	for {
+		select {
+		case <-quit:
+			break wait
+		case <-deadline:
+			fmt.Printf(Program+" stopping, the duration of %s elapsed\n", config.Duration)
+			break wait
+		case <-service.ConfigChanged():
+			if err = service.Reload(); err != nil {
+				break wait
+			}
+			watchErrors = service.Errors()
+		case <-service.Finished():
+			fmt.Printf(Program+" stopping, the maximum of %d runs was reached\n", config.MaxRuns)
+			break wait
+		case watchErr, ok := <-watchErrors:
+			if !ok {
+				watchErrors = nil
+			} else if service.config.ExitOnError {
+				fmt.Printf(Program+" stopping, caused by watcher error: %s\n", watchErr)
+				err = watchErr
+				break wait
+			}
+		}
+	}
+
+	if errStop := daemon.Stop(); errStop != nil {
+		fmt.Printf(Program+" stop failed: %s\n", errStop)
 	} else {
 		fmt.Println(Program + " stopped")
 	}
+	return
 }
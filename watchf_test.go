@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"testing"
+
+	"github.com/pinterb/watchf/daemon"
+)
+
+func TestResolveStopToken(t *testing.T) {
+	defer func(token, file string) { stopToken, tokenFile = token, file }(stopToken, tokenFile)
+	defer os.Unsetenv(TokenEnv)
+
+	f, err := ioutil.TempFile("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("from-file\nignored\n")
+	f.Close()
+
+	os.Setenv(TokenEnv, "from-env")
+	tests := []struct {
+		token    string
+		file     string
+		expected string
+	}{
+		{"from-flag", f.Name(), "from-flag"},
+		{"", f.Name(), "from-file"},
+		{"", "", "from-env"},
+	}
+	for _, test := range tests {
+		stopToken, tokenFile = test.token, test.file
+		if err := resolveStopToken(); err != nil {
+			t.Fatal(err)
+		}
+		if stopToken != test.expected {
+			t.Errorf("expected the token %q, got %q", test.expected, stopToken)
+		}
+	}
+
+	stopToken, tokenFile = "", f.Name()+".missing"
+	if err := resolveStopToken(); err == nil {
+		t.Error("a missing token file should be an error")
+	}
+}
+
+func TestWaitForStopWatcherError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pid file is written in the working directory
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer signal.Stop(quit)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.ExitOnError = true
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &ReloadingService{WatchService: w}
+	dmon := daemon.NewDaemon(Program, service)
+	if err := dmon.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	watchErr := errors.New("the watched directory was removed")
+	w.errors <- watchErr
+	if err := waitForStop(dmon, service, &config, os.Interrupt); err != watchErr {
+		t.Errorf("the watcher error should fail the stop with -exit-on-error, got %v", err)
+	}
+	if dmon.IsRunning() {
+		t.Error("the daemon should be stopped")
+	}
+}
@@ -5,6 +5,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func printExample() {
@@ -16,7 +18,21 @@ func printExample() {
 	fmt.Println("Example 3(with daemon):")
 	fmt.Println("  " + command + " -r -c \"rsync -aq $SRC $DST\" &")
 	fmt.Println("  " + command + " -s")
-	fmt.Println("Example 4(with configuration file):")
+	fmt.Println("Example 4(pause and resume the commands):")
+	fmt.Println("  kill -USR1 $(cat .watchf.pid)")
+	fmt.Println("Example 5(run the commands now, also the ones paused by -failure-threshold):")
+	fmt.Println("  kill -USR2 $(cat .watchf.pid)")
+	fmt.Println("Example 6(with configuration file):")
 	fmt.Println("  " + command + " -e \"modify,delete\" -c \"go vet\" -c \"go test\" -c \"go install\" -p \"\\.go$\" -w")
 	fmt.Println("  " + command)
 }
+
+func notifyPause(c chan os.Signal) bool {
+	signal.Notify(c, syscall.SIGUSR1)
+	return true
+}
+
+func notifyRun(c chan os.Signal) bool {
+	signal.Notify(c, syscall.SIGUSR2)
+	return true
+}
@@ -15,3 +15,11 @@ func printExample() {
 	fmt.Println("  " + command + " -e \"modify,delete\" -c \"go vet\" -c \"go test\" -c \"go install\" -p \"\\.go$\" -w")
 	fmt.Println("  " + command)
 }
+
+func notifyPause(c chan os.Signal) bool {
+	return false
+}
+
+func notifyRun(c chan os.Signal) bool {
+	return false
+}
@@ -0,0 +1,35 @@
+// +build freebsd openbsd netbsd darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// raiseWatchLimit raises the soft limit of open files to the hard limit, kqueue holds a descriptor for each watch
+func raiseWatchLimit() {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		Logln("cannot read the open files limit:", err)
+		return
+	}
+	if limit.Cur >= limit.Max {
+		return
+	}
+	Logf("raising the open files limit from %d to %d", limit.Cur, limit.Max)
+	limit.Cur = limit.Max
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		Logln("cannot raise the open files limit:", err)
+	}
+}
+
+// explainWatchError adds guidance to the errors caused by the open files limit
+func explainWatchError(err error) error {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+		return fmt.Errorf("%s (kqueue opens a file descriptor for each watched file and directory, raise the limit "+
+			"with `ulimit -n`, or watch less with -subtrees, -lazy or %s)", err, IgnoreFile)
+	}
+	return err
+}
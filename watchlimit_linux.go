@@ -0,0 +1,26 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// raiseWatchLimit does nothing, inotify watches do not hold file descriptors
+func raiseWatchLimit() {
+}
+
+// explainWatchError adds guidance to the errors caused by the inotify limits
+func explainWatchError(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return fmt.Errorf("%s (the inotify watch limit was reached, raise it with "+
+			"`sysctl fs.inotify.max_user_watches=524288`, or watch less with -subtrees, -lazy or %s)", err, IgnoreFile)
+	case errors.Is(err, syscall.EMFILE):
+		return fmt.Errorf("%s (the inotify instance limit was reached, raise it with "+
+			"`sysctl fs.inotify.max_user_instances=512`)", err)
+	}
+	return err
+}
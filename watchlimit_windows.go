@@ -0,0 +1,12 @@
+// +build windows
+
+package main
+
+// raiseWatchLimit does nothing on windows
+func raiseWatchLimit() {
+}
+
+// explainWatchError returns the error unchanged on windows
+func explainWatchError(err error) error {
+	return err
+}
@@ -1,25 +1,50 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"code.google.com/p/go.exp/fsnotify"
+	"github.com/mgutz/ansi"
 )
 
 const (
 	eventBufSize = 1024 * 1024
+	errorBufSize = 16
 	fsnCreate    = 1
 	fsnModify    = 2
 	fsnDelete    = 4
 	fsnRename    = 8
+	fsnAttrib    = 16
 
 	fsnAll = fsnModify | fsnDelete | fsnRename | fsnRename
+
+	// ReadyMarker is logged once the initial watches are registered, scripts may wait for it before making changes
+	ReadyMarker = Program + ": ready"
+
+	// ManualEventType is the event type (%t) of a run requested by the run signal
+	ManualEventType = "ENTRY_MANUAL"
+
+	// RootCheckInterval is how often the existence of the watch root is checked
+	RootCheckInterval = time.Duration(1) * time.Second
+
+	// WaitForPathMinBackoff and WaitForPathMaxBackoff bound the delay between the checks of a missing watched path
+	WaitForPathMinBackoff = time.Duration(100) * time.Millisecond
+	WaitForPathMaxBackoff = time.Duration(5) * time.Second
 )
 
 // EventBit is a simple way to track what filesytem events are valid.
@@ -40,6 +65,9 @@ var ModifyEvent = EventBit{Name: "modify", Value: fsnModify, Desc: "File was mod
 
 // RenameEvent is used to represent a fsnotify "rename" event
 var RenameEvent = EventBit{Name: "rename", Value: fsnRename, Desc: "File moved out of watched directory"}
+
+// AttribEvent is used to represent a fsnotify "attrib" event, it is not part of "all"
+var AttribEvent = EventBit{Name: "attrib", Value: fsnAttrib, Desc: "File permissions or owner changed (not included in all)"}
 var allEvent = EventBit{Value: fsnAll, Desc: "Create/Delete/Modify/Rename"}
 
 // ValidEvents map those fsnotify events that can be watched
@@ -48,44 +76,260 @@ var ValidEvents = map[string]EventBit{
 	"delete": DeleteEvent,
 	"modify": ModifyEvent,
 	"rename": RenameEvent,
+	"attrib": AttribEvent,
+}
+
+// AllEvents are the events watched by "all"
+var AllEvents = map[string]EventBit{
+	"create": CreateEvent,
+	"delete": DeleteEvent,
+	"modify": ModifyEvent,
+	"rename": RenameEvent,
 }
 
 // WatchService encapsulates all thats required to perform the 'watchf' operation
 type WatchService struct {
+	// EventTransform rewrites the event before the variables of the commands are evaluated, e.g. to map a watched
+	// path to the name the commands expect. Copy the event to keep its type: e := *evt; e.Name = name; return &e
+	EventTransform func(*fsnotify.FileEvent) *fsnotify.FileEvent
+
 	path   string
 	config *Config
+	// absPath is the absolute path of the watch root, the working directory "." can still be stat'ed once removed
+	absPath string
+
+	watcher *fsnotify.Watcher
+	rules   []*Rule
+	ignore  *IgnoreMatcher
+	filters *FilterRules
+
+	waitClose func(path string) error
+	hash      Hasher
+	schedule  []ScheduleWindow
+
+	executor     *Executor
+	publisher    *Publisher
+	errors       chan error
+	ready        chan bool
+	rootRestored chan bool
+	stdinPaths   chan string
+	done         chan bool
+	workerDone   chan bool
+	cancel       context.CancelFunc
+	stopped      chan error
+
+	stats     Stats
+	statsLock sync.Mutex
 
-	watcher              *fsnotify.Watcher
-	watchFlags           map[string]EventBit
-	includePatternRegexp *regexp.Regexp
+	paused     int32
+	startedAt  time.Time
+	lastExec   time.Time
+	adaptive   AdaptiveInterval
+	bucket     TokenBucket
+	runCounter uint64
+	runs       int
+	collapsing bool
+	latest     []*Trigger
+	collapsed  []string
+	finished   chan bool
+	exitCode   int
+	history    *History
+	// stateDirty indicates the history changed since the state file was saved
+	stateDirty bool
 
-	executor *Executor
+	configChanged chan bool
+	injected      chan *fsnotify.FileEvent
+	// manualRuns requests a run of the commands of every rule, see RunNow
+	manualRuns chan bool
 
-	dirs    map[string]bool
-	entries map[string]*FileEntry
+	dirs       map[string]bool
+	dirsLock   sync.RWMutex
+	manifest   map[string]bool
+	entries    map[string]*FileEntry
+	links      map[string]string
+	expanded   map[string]bool
+	created    map[string]time.Time
+	pending    map[string]*pendingFile
+	dirCounts  map[string]int
+	queued     map[string]time.Time
+	queuedLock sync.Mutex
+	breakers   map[string]*CircuitBreaker
+
+	// grep matches the appended lines of the tailed file, grepPartial holds the end of a line not yet terminated
+	grep        *regexp.Regexp
+	grepPartial []byte
+
+	// prehashed holds the hashes computed ahead by the hashers (-workers), until the worker handles their events
+	prehashed   map[*fsnotify.FileEvent]prehash
+	prehashLock sync.Mutex
 }
 
 // NewWatchService creates a new WatchService.
 func NewWatchService(path string, config *Config) (service *WatchService, err error) {
-	watchFlags, err := validateWatchFlags(config.Events)
+	rules, err := compileRules(config)
 	if err != nil {
 		return
 	}
 
-	includePatternRegexp, err := regexp.Compile(config.IncludePattern)
+	switch config.OnOverflow {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+	default:
+		err = &OptionError{"overflow policy", config.OnOverflow}
+		return
+	}
+
+	if config.LogFormat != LogFormatText && config.LogFormat != LogFormatJSON {
+		err = &OptionError{"log format", config.LogFormat}
+		return
+	}
+
+	if !config.AllowMissing && config.RemoteHost == "" {
+		commands := append(append(append([]string{}, config.DirCommands...), config.DeleteCommands...), config.OnFailure...)
+		for _, rule := range rules {
+			commands = append(commands, rule.commands...)
+		}
+		if err = lookupPrograms(commands); err != nil {
+			return
+		}
+	}
+
+	closeStrategy := config.CloseStrategy
+	if config.NoWaitClose {
+		closeStrategy = CloseNone
+	}
+	waitClose, err := closeWaiter(closeStrategy)
 	if err != nil {
 		return
 	}
+	hash, err := NewHasher(config.Hash, config.HashStrategies)
+	if err != nil {
+		return
+	}
+	if config.Timings > 0 {
+		filterTimings.Enable()
+		waitClose, hash = timedWaiter(waitClose), timedHasher(hash)
+	}
+
+	schedule, err := ParseSchedule(config.ActiveHours)
+	if err != nil {
+		return
+	}
+
+	var filters *FilterRules
+	if config.FilterFile != "" {
+		if filters, err = LoadFilterRules(config.FilterFile, config.FilterDefault); err != nil {
+			return
+		}
+	}
+
+	ignore, err := LoadIgnoreFile(filepath.Join(path, IgnoreFile))
+	if err != nil {
+		return
+	}
+
+	executor := &Executor{
+		Stdout:         os.Stdout,
+		Stderr:         os.Stderr,
+		OutputTemplate: config.OutputTemplate,
+		AtomicOutput:   config.AtomicOutput,
+		CombineOutput:  config.CombineOutput,
+		Timeout:        config.CommandTimeout,
+		Timeouts:       config.CommandTimeouts,
+		Allowed:        config.AllowedCommands,
+		Nice:           config.Nice,
+		MemLimit:       config.MemLimit,
+		ReadyFile:      config.ReadyFile,
+		ReadyTimeout:   config.ReadyTimeout,
+		Pty:            config.Pty,
+		FileMode:       os.FileMode(config.FileMode),
+		EventNames:     config.EventNames,
+	}
+	for eventType := range config.EventNames {
+		if !isEventType(eventType) {
+			err = &OptionError{"event type", eventType}
+			return
+		}
+	}
+	if config.MemLimit > 0 && !memLimitSupported {
+		log.Println("the memory limit is not supported on this platform, the commands are not limited")
+		executor.MemLimit = 0
+	}
+	if config.Pty && !ptySupported {
+		log.Println("pseudo-terminals are not supported on this platform, the commands run with pipes")
+		executor.Pty = false
+	}
+	if config.LogFile != "" {
+		var logFile *os.File
+		logFile, err = os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.FileMode(config.FileMode))
+		if err != nil {
+			return
+		}
+		executor.Stdout, executor.Stderr = logFile, logFile
+	}
+	if config.EnvFile != "" {
+		executor.Env, err = LoadEnvFile(config.EnvFile)
+		if err != nil {
+			return
+		}
+	}
+	if config.RemoteHost != "" {
+		executor.Remote, err = NewRemoteExecutor(config.RemoteHost, config.RemoteUser, config.RemoteKey)
+		if err != nil {
+			return
+		}
+	}
+	var grep *regexp.Regexp
+	if config.Grep != "" {
+		if config.TailFile == "" {
+			err = errors.New("-grep only applies to the file watched with -tail")
+			return
+		}
+		if grep, err = regexp.Compile(config.Grep); err != nil {
+			err = &PatternError{config.Grep, err}
+			return
+		}
+	}
+	var publisher *Publisher
+	if config.PublishTo != "" {
+		if publisher, err = NewPublisher(config.PublishTo); err != nil {
+			return
+		}
+	}
 
 	service = &WatchService{
-		path,
-		config,
-		nil,
-		watchFlags,
-		includePatternRegexp,
-		&Executor{os.Stdout, os.Stderr},
-		make(map[string]bool),
-		make(map[string]*FileEntry),
+		path:          path,
+		config:        config,
+		rules:         rules,
+		ignore:        ignore,
+		filters:       filters,
+		waitClose:     waitClose,
+		hash:          hash,
+		schedule:      schedule,
+		executor:      executor,
+		publisher:     publisher,
+		grep:          grep,
+		prehashed:     make(map[*fsnotify.FileEvent]prehash),
+		dirs:          make(map[string]bool),
+		entries:       make(map[string]*FileEntry),
+		links:         make(map[string]string),
+		expanded:      make(map[string]bool),
+		created:       make(map[string]time.Time),
+		pending:       make(map[string]*pendingFile),
+		dirCounts:     make(map[string]int),
+		queued:        make(map[string]time.Time),
+		breakers:      make(map[string]*CircuitBreaker),
+		history:       NewHistory(config.HistorySize),
+		bucket:        TokenBucket{Rate: config.RateLimit, Burst: config.Burst},
+		configChanged: make(chan bool, 1),
+		injected:      make(chan *fsnotify.FileEvent),
+		manualRuns:    make(chan bool, 1),
+		errors:        make(chan error, errorBufSize),
+		rootRestored:  make(chan bool, 1),
+		stdinPaths:    make(chan string),
+		ready:         make(chan bool),
+		finished:      make(chan bool),
+		done:          make(chan bool),
+		workerDone:    make(chan bool),
 	}
 	return
 }
@@ -93,79 +337,222 @@ func NewWatchService(path string, config *Config) (service *WatchService, err er
 func validateWatchFlags(events []string) (watchedEvents map[string]EventBit, err error) {
 	Logln("validating watch flags:")
 
-	var watchedFlags map[string]EventBit
-
 	// confirm that some events were asked to be watched
 	if len(events) == 0 {
-		err = fmt.Errorf("%s events is simply not enough", "zero")
+		err = ErrNoEvents
 		return
 	}
 
 	// and they are valid events
 	containsAll := false
 	for _, event := range events {
-		var eevent = strings.ToLower(event)
-		_, ok := ValidEvents[eevent]
+		var lcEvent = strings.ToLower(event)
+		_, ok := ValidEvents[lcEvent]
 
-		if eevent == "all" {
+		if lcEvent == "all" {
 			containsAll = true
 		} else if !ok {
-			err = fmt.Errorf("the event %s was not found", eevent)
+			err = &EventError{lcEvent}
 			return
 		}
 	}
 
 	// populate our map of watched events
+	watchedEvents = make(map[string]EventBit)
 	if containsAll {
-		watchedFlags = ValidEvents
-	} else {
-		watchedFlags = make(map[string]EventBit)
-		for _, event := range events {
-			var lcEvent = strings.ToLower(event)
-			switch {
-			case lcEvent == CreateEvent.Name:
-				watchedFlags[CreateEvent.Name] = CreateEvent
-			case lcEvent == DeleteEvent.Name:
-				watchedFlags[DeleteEvent.Name] = DeleteEvent
-			case lcEvent == ModifyEvent.Name:
-				watchedFlags[ModifyEvent.Name] = ModifyEvent
-			case lcEvent == RenameEvent.Name:
-				watchedFlags[RenameEvent.Name] = RenameEvent
-			}
+		for name, eventBit := range AllEvents {
+			watchedEvents[name] = eventBit
 		}
 	}
-
-	return watchedFlags, nil
+	for _, event := range events {
+		var lcEvent = strings.ToLower(event)
+		if eventBit, ok := ValidEvents[lcEvent]; ok {
+			watchedEvents[lcEvent] = eventBit
+		}
+	}
+	return
 }
 
-// Start the WatchService
+// Start the WatchService, it keeps running in the background until Stop is called
 func (w *WatchService) Start() (err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error, 1)
+	w.cancel = cancel
+	w.stopped = make(chan error, 1)
+
+	go func() {
+		w.stopped <- w.serve(ctx, started)
+	}()
+	return <-started
+}
+
+// StartContext starts the WatchService and blocks until the context is cancelled,
+// then it closes the watcher and waits for the worker to process the pending events
+func (w *WatchService) StartContext(ctx context.Context) error {
+	return w.serve(ctx, make(chan error, 1))
+}
+
+func (w *WatchService) serve(ctx context.Context, started chan<- error) (err error) {
+	w.startedAt = time.Now()
+	if w.config.HealthAddr != "" {
+		var server *http.Server
+		if server, err = w.startHealthServer(); err != nil {
+			started <- err
+			return
+		}
+		defer stopHealthServer(server)
+	}
+	if w.config.StateFile != "" {
+		w.restoreState()
+	}
+	if w.config.WaitForPath > 0 {
+		if err = w.waitForPaths(ctx); err != nil {
+			started <- err
+			return
+		}
+	}
 	events := make(chan *fsnotify.FileEvent, eventBufSize)
-	w.startWatcher(events) // events producer
-	w.startWorker(events)  // events consumer
+	if err = w.startWatcher(events); err != nil { // events producer
+		if w.watcher != nil {
+			w.watcher.Close()
+		}
+		started <- err
+		return
+	}
+	if w.watchesAttrib() {
+		w.cacheAttributes()
+	}
+	if w.config.Workers > 1 {
+		hashed := make(chan *fsnotify.FileEvent, eventBufSize)
+		w.startHashers(events, hashed)
+		w.startWorker(hashed) // events consumer
+	} else {
+		w.startWorker(events) // events consumer
+	}
+	w.startRootChecker()
+	if w.config.Timings > 0 {
+		w.startTimingsLogger()
+	}
+	close(w.ready)
+	log.Println(ReadyMarker)
+	started <- nil
+
+	<-ctx.Done()
+	close(w.done)
+	err = w.watcher.Close()
+	<-w.workerDone
+	if w.executor.Remote != nil {
+		w.executor.Remote.Close()
+	}
+	if w.publisher != nil {
+		w.publisher.Close()
+	}
+	if !w.config.NoSummary {
+		w.printSummary()
+		if w.config.Timings > 0 {
+			w.logTimings()
+		}
+	}
 	return
 }
 
-func (w *WatchService) startWatcher(events chan<- *fsnotify.FileEvent) (err error) {
+// Pause suppresses the commands, events are still processed to keep the caches up to date
+func (w *WatchService) Pause() {
+	atomic.StoreInt32(&w.paused, 1)
+}
+
+// Resume runs the commands again, the events seen while paused are not replayed
+func (w *WatchService) Resume() {
+	atomic.StoreInt32(&w.paused, 0)
+}
+
+// Paused indicates the commands are suppressed
+func (w *WatchService) Paused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
+}
+
+// RunNow requests a manual run of the commands of every rule, regardless of the interval. The commands tripped by
+// the -failure-threshold run too, and resume once they succeed. A request made while one is pending is merged into it.
+func (w *WatchService) RunNow() {
+	select {
+	case w.manualRuns <- true:
+	default:
+	}
+}
+
+// runManually runs the commands of every rule for the watch root (%f), %t is ENTRY_MANUAL
+func (w *WatchService) runManually() {
+	if w.Paused() {
+		Logf("%s: %s suppressed while paused", ManualEventType, w.path)
+		return
+	}
+	log.Printf("manual run of the commands")
+	for _, rule := range w.rules {
+		w.execute(&Trigger{Event: &fsnotify.FileEvent{Name: w.path}, Dir: true, Rule: rule, Manual: true})
+	}
+}
+
+// Ready returns a channel closed once the initial watches are registered and the worker is running, changes made
+// after that are reported
+func (w *WatchService) Ready() <-chan bool {
+	return w.ready
+}
+
+// Finished returns a channel closed once the commands ran the maximum number of runs (-max-runs)
+func (w *WatchService) Finished() <-chan bool {
+	return w.finished
+}
+
+// Inject queues the event as if the watcher reported it, e.g. to replay recorded events. The event type cannot be
+// set outside of fsnotify, copy an event of the watcher to keep its type: e := *evt; e.Name = name; w.Inject(&e)
+func (w *WatchService) Inject(evt *fsnotify.FileEvent) error {
+	select {
+	case <-w.ready:
+	default:
+		return errors.New("the watch service was not started")
+	}
+	select {
+	case w.injected <- evt:
+		return nil
+	case <-w.done:
+		return errors.New("the watch service was stopped")
+	}
+}
+
+// Errors returns the errors reported by the underlying watcher, the channel is closed when the watcher is closed
+func (w *WatchService) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *WatchService) startWatcher(events chan *fsnotify.FileEvent) (err error) {
+	raiseWatchLimit()
 	w.watcher, err = fsnotify.NewWatcher()
 	if err != nil {
 		return
 	}
 
 	go func() {
+		defer close(w.errors)
+		defer close(events)
 		for {
 			select {
 			case evt, ok := <-w.watcher.Event:
 				if ok {
 					// emit events from watcher.Event to buffered channel in order to non-ignored events
-					events <- evt
+					w.emit(events, evt)
 				} else {
-					close(events)
 					return
 				}
+			case evt := <-w.injected:
+				w.emit(events, evt)
 			case err, ok := <-w.watcher.Error:
 				if ok {
 					log.Println("watcher err:", err)
+					select {
+					case w.errors <- err:
+					default:
+						// nobody is listening, the error has been logged already
+					}
 				} else {
 					return
 				}
@@ -173,72 +560,852 @@ func (w *WatchService) startWatcher(events chan<- *fsnotify.FileEvent) (err erro
 		}
 	}()
 
-	err = w.watchFolders()
+	if err = w.watchFolders(); err != nil {
+		return
+	}
+	if w.config.WatchConfig && !w.isDir(filepath.Dir(configFile)) {
+		// the configuration file is outside of the watched directories
+		if err = w.watcher.Watch(configFile); err != nil {
+			err = fmt.Errorf("cannot watch the configuration file: %w", err)
+		}
+	}
 	return
 }
 
-func (w *WatchService) watchFolders() (err error) {
-	if w.config.Recursive {
-		err = filepath.Walk(w.path, func(path string, info os.FileInfo, errPath error) error {
-			if info.IsDir() {
-				relativePath := "./" + path
-				if errPath == nil {
-					w.dirs[relativePath] = true
-					Logln("watching: ", relativePath)
-					errWatcher := w.watcher.Watch(path)
-					if errWatcher != nil {
-						return errWatcher
-					}
-				} else {
-					log.Printf("skip dir %s, caused by: %s\n", relativePath, errPath)
-					return filepath.SkipDir
-				}
+// waitForPaths retries with backoff until the watched paths exist, for the directories created after watchf started
+func (w *WatchService) waitForPaths(ctx context.Context) (err error) {
+	paths := []string{w.path}
+	if w.config.TailFile != "" {
+		paths = []string{w.config.TailFile}
+	} else if w.config.Recursive {
+		paths = w.treeRoots()
+	}
+
+	deadline := time.Now().Add(w.config.WaitForPath)
+	backoff := WaitForPathMinBackoff
+	for _, path := range paths {
+		for {
+			if _, err = os.Stat(path); err == nil || !os.IsNotExist(err) {
+				break
+			}
+			if time.Now().Add(backoff).After(deadline) {
+				return fmt.Errorf("%s did not appear within %s: %w", path, w.config.WaitForPath, err)
+			}
+			log.Printf("waiting for %s to exist, retrying in %s", path, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			return nil
+			if backoff *= 2; backoff > WaitForPathMaxBackoff {
+				backoff = WaitForPathMaxBackoff
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// emit queues the event for the worker, applying the overflow policy when the buffer is full
+func (w *WatchService) emit(events chan *fsnotify.FileEvent, evt *fsnotify.FileEvent) {
+	if w.config.Dedup && w.isQueuedDuplicate(evt, time.Now()) {
+		w.updateStats(func(stats *Stats) {
+			stats.DuplicateEvents++
 		})
-	} else {
-		err = w.watcher.Watch(w.path)
+		Logf("%s: %s skipped, an identical event is queued", getEventType(evt), evt.Name)
+		return
+	}
+
+	select {
+	case events <- evt:
+		return
+	default:
+	}
+
+	dropped := evt
+	switch w.config.OnOverflow {
+	case OverflowDropNewest:
+	case OverflowDropOldest:
+		select {
+		case dropped = <-events:
+		default:
+		}
+		select {
+		case events <- evt:
+		default:
+			dropped = evt
+		}
+	default:
+		events <- evt
+		return
+	}
+
+	var count uint64
+	w.updateStats(func(stats *Stats) {
+		stats.OverflowEvents++
+		count = stats.OverflowEvents
+	})
+	if count == 1 {
+		log.Printf("the event buffer is full, dropping events (%s)", w.config.OnOverflow)
+	}
+	Logf("%s: %s dropped by the overflow policy (%d dropped so far)", getEventType(dropped), dropped.Name, count)
+}
+
+func (w *WatchService) watchFolders() (err error) {
+	if w.config.TailFile != "" {
+		err = w.watchTail()
+	} else if w.config.WatchStdin {
+		w.watchStdin()
+	} else if w.config.FromFile != "" {
+		err = w.watchManifest()
+	} else if w.config.Recursive && len(w.config.Subtrees) > 0 && !w.subtreesExist() {
+		err = fmt.Errorf("the subtrees %s must exist, start with -allow-missing to watch the other ones", w.config.Subtrees)
+	} else if w.config.Recursive && w.config.LazyRecursive {
+		for _, root := range w.treeRoots() {
+			if err = w.watchLazy(root); err != nil {
+				return
+			}
+			w.expandDir(root)
+		}
+	} else if w.config.Recursive {
+		for _, root := range w.treeRoots() {
+			if err = w.watchTree(root); err != nil {
+				return
+			}
+		}
+	} else if err = w.watcher.Watch(w.path); err == nil {
+		w.addDir(w.path)
+	}
+	if err != nil {
+		err = explainWatchError(err)
 	}
 	return
 }
 
+// subtreesExist checks the -subtrees are directories, the missing ones are logged, they are allowed with -allow-missing
+func (w *WatchService) subtreesExist() bool {
+	exist := true
+	for _, root := range w.treeRoots() {
+		if st, err := os.Stat(root); err != nil || !st.IsDir() {
+			log.Printf("the subtree %s is not a directory, it is not watched", root)
+			exist = false
+		}
+	}
+	return exist || w.config.AllowMissing
+}
+
+// treeRoots returns the directories to watch recursively, the configured subtrees or else the watch path
+func (w *WatchService) treeRoots() []string {
+	if len(w.config.Subtrees) == 0 {
+		return []string{w.path}
+	}
+
+	roots := make([]string, 0, len(w.config.Subtrees))
+	for _, subtree := range w.config.Subtrees {
+		roots = append(roots, filepath.Join(w.path, subtree))
+	}
+	return roots
+}
+
+// inTrees indicates the path is inside one of the recursively watched directories
+func (w *WatchService) inTrees(path string) bool {
+	path = filepath.Clean(path)
+	for _, root := range w.treeRoots() {
+		if root == "." || path == root || strings.HasPrefix(path, root+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WatchService) watchTree(root string) error {
+	return w.walkTree(root, func(path string) error {
+		w.addDir(path)
+		Logln("watching: ", path)
+		return w.watcher.Watch(path)
+	}, func(path string, reason string, err error) {
+		if err != nil {
+			log.Printf("skip dir %s, %s\n", path, reason)
+		} else {
+			Logln("skip dir", path, reason)
+		}
+	})
+}
+
+// walkTree walks the directories of a recursive watch, calling watch for each directory to watch and skip with the
+// reason of each directory left out, the tree command previews a watch with the same walk
+func (w *WatchService) walkTree(root string, watch func(path string) error, skip func(path string, reason string, err error)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, errPath error) error {
+		if info != nil && info.IsDir() {
+			if w.ignore.Match(w.relativeToRoot(path), true) {
+				skip(path, "ignored by "+IgnoreFile, nil)
+				return filepath.SkipDir
+			}
+			if w.filters.Excluded(w.relativeToRoot(path), true) {
+				skip(path, "excluded by "+w.config.FilterFile, nil)
+				return filepath.SkipDir
+			}
+			if errPath != nil {
+				skip(path, "caused by: "+errPath.Error(), errPath)
+				return filepath.SkipDir
+			}
+			return watch(path)
+		}
+		return nil
+	})
+}
+
+// watchLazy watches a single directory of a lazily watched tree, its subdirectories are watched by expandDir
+func (w *WatchService) watchLazy(dir string) error {
+	dir = filepath.Clean(dir)
+	if w.isDir(dir) {
+		return nil
+	}
+	Logln("watching: ", dir)
+	if err := w.watcher.Watch(dir); err != nil {
+		return err
+	}
+	w.addDir(dir)
+	return nil
+}
+
+// expandDir watches the subdirectories of a lazily watched directory the first time something happens in it
+func (w *WatchService) expandDir(dir string) {
+	dir = filepath.Clean(dir)
+	if w.expanded[dir] || !w.isDir(dir) {
+		return
+	}
+	w.expanded[dir] = true
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		Logln(err)
+		return
+	}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if !info.IsDir() || w.ignore.Match(w.relativeToRoot(path), true) || w.filters.Excluded(w.relativeToRoot(path), true) {
+			continue
+		}
+		if err := w.watchLazy(path); err != nil {
+			log.Printf("skip dir %s, caused by: %s\n", path, err)
+		}
+	}
+}
+
+// watchManifest watches the parent directories of the files listed in the manifest and the manifest itself
+func (w *WatchService) watchManifest() (err error) {
+	manifest, err := LoadManifest(w.config.FromFile)
+	if err != nil {
+		return
+	}
+	w.manifest = manifest
+
+	dirs := map[string]bool{filepath.Dir(w.config.FromFile): true}
+	for path := range w.manifest {
+		dirs[filepath.Dir(path)] = true
+	}
+
+	for dir := range dirs {
+		if w.isDir(dir) {
+			continue
+		}
+		Logln("watching: ", dir)
+		if err = w.watcher.Watch(dir); err != nil {
+			return
+		}
+		w.addDir(dir)
+	}
+	return
+}
+
+// watchTail watches a single file, remembering its current size so that only appended content is reported
+func (w *WatchService) watchTail() (err error) {
+	path := filepath.Clean(w.config.TailFile)
+	entry, err := newFileEntry(path, w.hash)
+	if err != nil {
+		return
+	}
+	w.entries[path] = entry
+	w.manifest = map[string]bool{path: true}
+
+	dir := filepath.Dir(path)
+	Logln("watching: ", dir)
+	if err = w.watcher.Watch(dir); err != nil {
+		return
+	}
+	w.addDir(dir)
+	return
+}
+
+// followTail tracks the file created at the tailed path after a rotation from its start, like tail -F
+func (w *WatchService) followTail(evt *fsnotify.FileEvent) {
+	path := filepath.Clean(w.config.TailFile)
+	if filepath.Clean(evt.Name) != path {
+		return
+	}
+	switch {
+	case evt.IsRename(), evt.IsDelete():
+		log.Printf("tailed file %s was rotated, waiting for the new file", path)
+	case evt.IsCreate():
+		log.Printf("following the new file %s", path)
+		w.entries[path] = &FileEntry{}
+		w.grepPartial = nil
+	}
+}
+
+func (w *WatchService) isManifest(path string) bool {
+	return w.config.FromFile != "" && filepath.Clean(path) == filepath.Clean(w.config.FromFile)
+}
+
 func (w *WatchService) startWorker(events <-chan *fsnotify.FileEvent) {
 	go func() {
-		var lastExec time.Time
-		for evt := range events {
-			Logf("%s: %s", getEventType(evt), evt.Name)
-
-			w.syncWatchersAndCaches(evt)
-
-			if checkPatternMatching(w.includePatternRegexp, evt) {
-				if checkEventType(w.watchFlags, evt) {
-					if checkExecInterval(lastExec, w.config.Interval, time.Now()) {
-						if w.isDir(evt.Name) {
-							lastExec = time.Now()
-							w.run(evt)
-						} else {
-							// ignore file attributes changed
-							if evt.IsModify() && !checkFileContentChanged(w.entries, evt.Name) {
-								continue
-							}
-							lastExec = time.Now()
-							w.run(evt)
-						}
-					} else {
-						Logf("%s: %s dropped", getEventType(evt), evt.Name)
+		defer close(w.workerDone)
+
+		var symlinkTicks <-chan time.Time
+		if w.config.WatchSymlinks {
+			ticker := time.NewTicker(SymlinkCheckInterval)
+			defer ticker.Stop()
+			symlinkTicks = ticker.C
+			w.scanSymlinks()
+		}
+
+		var stableTicks <-chan time.Time
+		if w.config.StableFor > 0 {
+			ticker := time.NewTicker(StableCheckInterval)
+			defer ticker.Stop()
+			stableTicks = ticker.C
+		}
+
+		var stateTicks <-chan time.Time
+		if w.config.StateFile != "" && w.config.HistorySize > 0 {
+			ticker := time.NewTicker(StateSaveInterval)
+			defer ticker.Stop()
+			stateTicks = ticker.C
+			defer func() {
+				if w.stateDirty {
+					w.flushState()
+				}
+			}()
+		}
+
+		for {
+			select {
+			case evt, ok := <-events:
+				if !ok {
+					return
+				}
+				w.processEvent(evt)
+				if w.config.LatestWins && !w.drainLatest(events) {
+					return
+				}
+			case <-w.rootRestored:
+				w.rewatch()
+			case <-w.manualRuns:
+				w.runManually()
+			case path := <-w.stdinPaths:
+				w.addPath(path)
+			case <-symlinkTicks:
+				for _, path := range w.scanSymlinks() {
+					w.handleRetarget(path)
+				}
+			case now := <-stableTicks:
+				w.checkStableFiles(now)
+			case <-stateTicks:
+				if w.stateDirty {
+					w.flushState()
+				}
+			}
+		}
+	}()
+}
+
+func (w *WatchService) processEvent(evt *fsnotify.FileEvent) {
+	if w.config.Dedup {
+		w.dequeued(evt)
+	}
+	if w.isStateFile(evt.Name) {
+		// the writes of the state file are not events, recording them would save the state file again
+		return
+	}
+	if w.config.LogFormat != LogFormatJSON {
+		Logf("%s: %s", getEventType(evt), evt.Name)
+	}
+	w.updateStats(func(stats *Stats) {
+		stats.Events++
+	})
+
+	w.syncWatchersAndCaches(evt)
+	if w.config.FollowRename && w.config.TailFile != "" {
+		w.followTail(evt)
+	}
+
+	if w.isManifest(evt.Name) && (evt.IsCreate() || evt.IsModify()) {
+		Logln("reloading manifest: ", w.config.FromFile)
+		if err := w.watchManifest(); err != nil {
+			log.Println("cannot reload manifest:", err)
+		}
+	}
+
+	if w.isConfigFile(evt.Name) {
+		w.configFileChanged()
+	}
+
+	matched, runID := w.handleEvent(evt)
+	w.recordEvent(evt, matched, runID)
+	if w.config.Workers > 1 {
+		// the hash computed ahead for a filtered event was not used
+		w.takePrehash(evt)
+	}
+}
+
+// startRootChecker periodically checks the existence of the watch root, the watches are lost when the root is
+// removed so they are re-established by the worker once it reappears
+func (w *WatchService) startRootChecker() {
+	var err error
+	if w.absPath, err = filepath.Abs(w.path); err != nil {
+		log.Printf("cannot resolve the watch root %s, its removal is not detected: %s", w.path, err)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(RootCheckInterval)
+		defer ticker.Stop()
+
+		exists := true
+		for {
+			select {
+			case <-ticker.C:
+				_, err := os.Stat(w.absPath)
+				switch {
+				case err != nil && exists:
+					log.Printf("watch root %s was removed, waiting for it to reappear", w.path)
+					exists = false
+				case err == nil && !exists:
+					exists = true
+					select {
+					case w.rootRestored <- true:
+					case <-w.done:
+						return
 					}
-				} // if event match
-			} // if pattern match
-		} // for each event
+				}
+			case <-w.done:
+				return
+			}
+		}
 	}()
 }
 
+func (w *WatchService) rewatch() {
+	if filepath.Clean(w.path) == "." {
+		// the working directory is still the removed directory, "." must name the new one
+		if err := os.Chdir(w.absPath); err != nil {
+			log.Printf("cannot re-attach to watch root %s: %s", w.absPath, err)
+			return
+		}
+	}
+	for _, dir := range w.WatchedDirs() {
+		w.watcher.RemoveWatch(dir)
+	}
+	w.dirsLock.Lock()
+	w.dirs = make(map[string]bool)
+	w.dirsLock.Unlock()
+	w.entries = make(map[string]*FileEntry)
+	w.expanded = make(map[string]bool)
+
+	if ignore, err := LoadIgnoreFile(filepath.Join(w.path, IgnoreFile)); err != nil {
+		log.Println("cannot reload", IgnoreFile+":", err)
+	} else {
+		w.ignore = ignore
+	}
+
+	if err := w.watchFolders(); err != nil {
+		log.Printf("cannot re-attach to watch root %s: %s", w.path, err)
+		return
+	}
+	log.Printf("watch root %s reappeared, watches re-attached", w.path)
+}
+
+// handleEvent runs the commands when the event passes the filters, runID is empty when nothing was executed
+func (w *WatchService) handleEvent(evt *fsnotify.FileEvent) (matched bool, runID string) {
+	if !w.passesFilters(evt) {
+		return
+	}
+	rules := matchRules(w.rules, evt, w.relativeToRoot(evt.Name))
+	if len(rules) == 0 {
+		return
+	}
+	if w.config.TailFile != "" && !evt.IsModify() {
+		return
+	}
+	matched = true
+
+	if w.config.DirCountThreshold > 0 {
+		if (evt.IsCreate() || evt.IsDelete() || evt.IsRename()) && !w.isDir(evt.Name) {
+			// the counts are kept per directory, the first matching rule counts the entries
+			runID = w.handleDirCount(evt, rules[0])
+		}
+		return
+	}
+	if w.config.StableFor > 0 && (evt.IsCreate() || evt.IsModify()) && !w.isDir(evt.Name) {
+		w.deferUntilStable(evt, rules)
+		return
+	}
+	runID = w.dispatch(evt, rules)
+	return
+}
+
+// passesFilters applies the path filters of the events: the manifest, the ignore file, the filter file, the files
+// written by watchf, the excluded extensions and the file age
+func (w *WatchService) passesFilters(evt *fsnotify.FileEvent) bool {
+	if w.manifest != nil && !checkManifest(w.manifest, evt) {
+		return false
+	}
+	if checkIgnored(w.ignore, w.relativeToRoot(evt.Name), w.isDir(evt.Name)) {
+		return false
+	}
+	if w.filters != nil && !checkFilterRules(w.filters, w.relativeToRoot(evt.Name), w.isDir(evt.Name)) {
+		return false
+	}
+	if w.inSyncDestination(evt.Name) || w.isStateFile(evt.Name) || w.isReadyFile(evt.Name) || w.isConfigFile(evt.Name) {
+		return false
+	}
+	if len(w.config.ExcludeExts) > 0 && checkExcludedExt(w.config.ExcludeExts, evt.Name) {
+		return false
+	}
+	if (w.config.MinAge > 0 || w.config.MaxAge > 0) && !evt.IsDelete() && !evt.IsRename() &&
+		!checkFileAge(evt.Name, w.config.MinAge, w.config.MaxAge, time.Now()) {
+		return false
+	}
+	return true
+}
+
+// dispatch applies the interval and change filters to the matched event and runs the commands of the rules whose
+// interval passed, the change filters are applied once for all the rules
+func (w *WatchService) dispatch(evt *fsnotify.FileEvent, rules []*Rule) (runID string) {
+	return w.dispatchTrigger(&Trigger{Event: evt, Dir: w.isDir(evt.Name), Rule: rules[0]}, rules)
+}
+
+// dispatchTrigger is dispatch for a trigger built by the caller, e.g. the retarget of a symlink
+func (w *WatchService) dispatchTrigger(trigger *Trigger, rules []*Rule) (runID string) {
+	evt := trigger.Event
+	if w.config.AdaptiveInterval {
+		w.adaptive.ObserveEvent(time.Now())
+	}
+
+	// ready are the rules whose interval passed, limited tells whether any rule has an interval. With -rate-limit each
+	// rule run spends a token, the bucket is checked once for the event and the rules beyond its tokens are dropped
+	var ready []*Rule
+	limited := w.config.RateLimit > 0
+	now := time.Now()
+	var runs int
+	if w.config.RateLimit > 0 {
+		runs = w.bucket.Runs(now)
+	}
+	for _, rule := range rules {
+		interval := rule.interval
+		if w.config.AdaptiveInterval {
+			interval = w.adaptive.Interval(interval)
+		}
+		limited = limited || interval > 0
+
+		var passed bool
+		if w.config.RateLimit > 0 {
+			passed = len(ready) < runs
+		} else {
+			passed = checkExecInterval(rule.lastExec, interval, now)
+		}
+		if passed {
+			ready = append(ready, rule)
+		} else if len(rules) > 1 {
+			Logf("%s: %s dropped by the interval limit of rule %d", trigger.eventType(), evt.Name, rule.index)
+		}
+	}
+	intervalPassed := len(ready) > 0
+	if !intervalPassed && w.config.CountThreshold == 0 {
+		var dropped uint64
+		w.updateStats(func(stats *Stats) {
+			stats.DroppedEvents++
+			stats.LastDropped = evt.Name
+			dropped = stats.DroppedEvents
+		})
+		Logf("%s: %s dropped (%d dropped by the interval limit so far)", trigger.eventType(), evt.Name, dropped)
+		return
+	}
+
+	if w.config.TailFile != "" {
+		path := filepath.Clean(evt.Name)
+		if !w.checkContentChanged(evt, path) {
+			return
+		}
+		appended, err := readAppendedContent(w.entries[path], path)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if w.grep != nil {
+			if appended = w.grepAppended(trigger, appended); appended == nil {
+				Logf("%s: %s no appended line matches %s", trigger.eventType(), evt.Name, w.grep)
+				return
+			}
+		}
+		if len(appended) == 0 {
+			return
+		}
+		trigger.Stdin = appended
+	} else if evt.IsAttrib() {
+		if trigger.Dir {
+			return
+		}
+		if trigger.AttribChanges = checkAttributesChanged(w.entries, evt.Name, w.hash); len(trigger.AttribChanges) == 0 {
+			return
+		}
+		trigger.Attrib = formatAttribChanges(trigger.AttribChanges)
+	} else if !trigger.Dir && evt.IsModify() && !w.checkContentChanged(evt, evt.Name) {
+		// ignore file attributes changed
+		return
+	}
+	if w.config.DiffSummary && w.config.TailFile == "" && !trigger.Dir && (evt.IsCreate() || evt.IsModify()) {
+		w.diffContent(trigger)
+	}
+
+	if w.config.CreateWindow > 0 && evt.IsModify() && !trigger.Dir && checkFollowsCreate(w.created, evt.Name, w.config.CreateWindow, time.Now()) {
+		Logf("%s: %s merged into the create event", trigger.eventType(), evt.Name)
+		return
+	}
+
+	if !checkStartupGrace(w.startedAt, w.config.StartupGrace, time.Now()) {
+		Logf("%s: %s suppressed during startup grace period", trigger.eventType(), evt.Name)
+		return
+	}
+
+	if w.Paused() {
+		Logf("%s: %s suppressed while paused", trigger.eventType(), evt.Name)
+		return
+	}
+
+	if !checkSchedule(w.schedule, time.Now()) {
+		Logf("%s: %s suppressed outside the active hours", trigger.eventType(), evt.Name)
+		return
+	}
+
+	if w.config.CountThreshold > 0 {
+		var count int
+		w.updateStats(func(stats *Stats) {
+			stats.PendingEvents++
+			count = stats.PendingEvents
+		})
+		// either the count or an elapsed interval may trigger a run
+		if !(limited && intervalPassed) {
+			if !checkEventCount(count, w.config.CountThreshold) {
+				Logf("%s: %s counted (%d of %d events)", trigger.eventType(), evt.Name, count, w.config.CountThreshold)
+				return
+			}
+			ready = rules
+		}
+		w.updateStats(func(stats *Stats) {
+			stats.PendingEvents = 0
+		})
+	}
+
+	if w.config.SyncTo != "" {
+		w.syncToDestination(trigger)
+	}
+
+	if w.collapsing {
+		Logf("%s: %s collapsed, the latest event runs once the queued events are drained", trigger.eventType(), evt.Name)
+		for _, rule := range ready {
+			w.collapseLatest(trigger.forRule(rule))
+		}
+		w.collapse(evt.Name)
+		return
+	}
+	for _, rule := range ready {
+		if w.config.GrepEach && w.grep != nil {
+			runID = w.executeLines(trigger.forRule(rule))
+		} else {
+			runID = w.execute(trigger.forRule(rule))
+		}
+	}
+	return
+}
+
+// execute runs the commands for the trigger after the -run-delay, keeping track of the execution time
+func (w *WatchService) execute(trigger *Trigger) (runID string) {
+	if w.config.RunDelay > 0 {
+		Logf("%s: %s runs in %s", trigger.eventType(), trigger.Event.Name, w.config.RunDelay)
+		select {
+		case <-time.After(w.config.RunDelay):
+		case <-w.done:
+			return
+		}
+	}
+	w.lastExec = time.Now()
+	trigger.Rule.lastExec = w.lastExec
+	if w.config.RateLimit > 0 {
+		w.bucket.Take(w.lastExec)
+	}
+	if w.config.CreateWindow > 0 && trigger.Event.IsCreate() && !trigger.Dir {
+		w.recordCreate(trigger.Event.Name, w.lastExec)
+	}
+	runID = w.run(trigger)
+	w.adaptive.ObserveRun(time.Since(w.lastExec))
+	return
+}
+
+// drainLatest processes the events queued while the commands ran, only the latest trigger among them runs, and again
+// for the events queued meanwhile. It returns false when the events channel was closed
+func (w *WatchService) drainLatest(events <-chan *fsnotify.FileEvent) bool {
+	for {
+		w.collapsing = true
+	drain:
+		for {
+			select {
+			case evt, ok := <-events:
+				if !ok {
+					w.collapsing = false
+					w.runLatest()
+					return false
+				}
+				w.processEvent(evt)
+			default:
+				break drain
+			}
+		}
+		w.collapsing = false
+
+		if !w.runLatest() {
+			return true
+		}
+	}
+}
+
+// runLatest runs the commands for the latest collapsed trigger of each rule, if any
+func (w *WatchService) runLatest() bool {
+	if len(w.latest) == 0 {
+		return false
+	}
+	latest, files := w.latest, w.collapsed
+	w.latest, w.collapsed = nil, nil
+	for _, trigger := range latest {
+		trigger.Files = files
+		if w.config.GrepEach && w.grep != nil {
+			w.executeLines(trigger)
+		} else {
+			w.execute(trigger)
+		}
+	}
+	return true
+}
+
+// collapseLatest keeps the trigger as the latest one of its rule, the content appended to the tailed file by the
+// collapsed events is kept so that no matching line is lost
+func (w *WatchService) collapseLatest(trigger *Trigger) {
+	for i, latest := range w.latest {
+		if latest.Rule == trigger.Rule {
+			if len(latest.Stdin) > 0 {
+				trigger.Stdin = append(append([]byte(nil), latest.Stdin...), trigger.Stdin...)
+			}
+			w.latest[i] = trigger
+			return
+		}
+	}
+	w.latest = append(w.latest, trigger)
+}
+
+// collapse adds the file to the files of the collapsed run, once
+func (w *WatchService) collapse(path string) {
+	for _, collapsed := range w.collapsed {
+		if collapsed == path {
+			return
+		}
+	}
+	w.collapsed = append(w.collapsed, path)
+}
+
+// recordCreate remembers when the commands ran for a new file, forgetting the creates older than the window
+func (w *WatchService) recordCreate(path string, now time.Time) {
+	for createdPath, createdAt := range w.created {
+		if now.Sub(createdAt) > w.config.CreateWindow {
+			delete(w.created, createdPath)
+		}
+	}
+	w.created[path] = now
+}
+
+// EventRecord is the JSON representation of a processed event
+type EventRecord struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Path     string    `json:"path"`
+	Matched  bool      `json:"matched"`
+	Executed bool      `json:"executed"`
+	RunID    string    `json:"run_id,omitempty"`
+	ExitCode int       `json:"exit_code,omitempty"`
+}
+
+// recordEvent adds the processed event to the history and logs it
+func (w *WatchService) recordEvent(evt *fsnotify.FileEvent, matched bool, runID string) {
+	record := EventRecord{time.Now(), getEventType(evt), evt.Name, matched, runID != "", runID, 0}
+	if runID != "" {
+		record.ExitCode = w.exitCode
+	}
+	w.history.Add(record)
+	if matched && w.publisher != nil {
+		w.publisher.Publish(&record)
+	}
+	if w.config.StateFile != "" && w.config.HistorySize > 0 {
+		// the state file is saved by the worker every StateSaveInterval, not on each event
+		w.stateDirty = true
+	}
+
+	if w.config.LogFormat == LogFormatJSON {
+		w.logEvent(&record)
+	} else if w.config.ShowEvents {
+		w.showEvent(evt, matched, runID)
+	}
+}
+
+func (w *WatchService) logEvent(record *EventRecord) {
+	if err := json.NewEncoder(log.Writer()).Encode(record); err != nil {
+		log.Println(err)
+	}
+}
+
+// showEvent logs a one-line summary of the processed event
+func (w *WatchService) showEvent(evt *fsnotify.FileEvent, matched bool, runID string) {
+	switch {
+	case runID != "":
+		log.Println(ansi.Color(fmt.Sprintf("%s: %s matched, run %s", getEventType(evt), evt.Name, runID), "green"))
+	case matched:
+		log.Println(ansi.Color(fmt.Sprintf("%s: %s matched, not run", getEventType(evt), evt.Name), "yellow"))
+	default:
+		log.Printf("%s: %s not matched", getEventType(evt), evt.Name)
+	}
+}
+
+// EventTypes lists the event types %t expands to, the keys of the EventNames option
+var EventTypes = []string{"ENTRY_CREATE", "ENTRY_ATTRIB", "ENTRY_MODIFY", "ENTRY_DELETE", "ENTRY_RENAME", CountEventType,
+	RetargetEventType, ManualEventType}
+
+func isEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
 func getEventType(evt *fsnotify.FileEvent) string {
 	eventType := ""
 
 	switch {
 	case evt.IsCreate():
 		eventType = "ENTRY_CREATE"
+	case evt.IsAttrib():
+		eventType = "ENTRY_ATTRIB"
 	case evt.IsModify():
 		eventType = "ENTRY_MODIFY"
 	case evt.IsDelete():
@@ -251,23 +1418,34 @@ func getEventType(evt *fsnotify.FileEvent) string {
 
 func (w *WatchService) syncWatchersAndCaches(evt *fsnotify.FileEvent) {
 	path := evt.Name
+	if w.config.Recursive && w.config.LazyRecursive && !evt.IsDelete() && !evt.IsRename() && w.inTrees(path) {
+		w.expandDir(filepath.Dir(path))
+	}
 	switch {
 	case evt.IsCreate():
 		stat, err := os.Stat(path)
 		if err != nil {
 			Logln(err)
 		} else {
-			if stat.IsDir() {
+			if stat.IsDir() && w.inTrees(path) && !w.ignore.Match(w.relativeToRoot(path), true) &&
+				!w.filters.Excluded(w.relativeToRoot(path), true) {
 				Logln("watching: ", path)
-				w.dirs[path] = true
-				w.watcher.Watch(path)
+				w.addDir(path)
+				if err := w.watcher.Watch(path); err != nil {
+					log.Println(explainWatchError(err))
+				}
+			} else if stat.Mode().IsRegular() && w.config.TailFile == "" && len(matchRules(w.rules, evt, w.relativeToRoot(path))) > 0 {
+				// the baseline of a new file, so that its first modify only runs the commands if the content changed
+				if entry, err := newFileEntry(path, w.hash); err == nil {
+					w.entries[path] = entry
+				}
 			}
 		}
 
 	case evt.IsRename(), evt.IsDelete():
 		if w.isDir(path) {
 			Logln("remove watching: ", path)
-			delete(w.dirs, path)
+			w.removeDir(path)
 			w.watcher.RemoveWatch(path)
 
 			dirPath := path + string(os.PathSeparator)
@@ -282,21 +1460,171 @@ func (w *WatchService) syncWatchersAndCaches(evt *fsnotify.FileEvent) {
 	}
 }
 
+// relativeToRoot returns the path relative to the watch root, as used by the patterns, the ignore and the filter rules
+func (w *WatchService) relativeToRoot(path string) string {
+	if rel, err := filepath.Rel(w.path, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// isDir indicates the path is a watched directory, the paths are cleaned so that ./src and src are the same
 func (w *WatchService) isDir(path string) bool {
-	_, ok := w.dirs[path]
+	w.dirsLock.RLock()
+	defer w.dirsLock.RUnlock()
+	_, ok := w.dirs[filepath.Clean(path)]
 	return ok
 }
 
-func (w *WatchService) run(evt *fsnotify.FileEvent) {
-	for _, command := range w.config.Commands {
-		err := w.executor.execute(command, evt)
-		if err != nil && !ContinueOnError {
-			break
+func (w *WatchService) addDir(path string) {
+	w.dirsLock.Lock()
+	defer w.dirsLock.Unlock()
+	w.dirs[filepath.Clean(path)] = true
+}
+
+func (w *WatchService) removeDir(path string) {
+	w.dirsLock.Lock()
+	defer w.dirsLock.Unlock()
+	delete(w.dirs, filepath.Clean(path))
+}
+
+// WatchedDirs returns a sorted snapshot of the directories currently being watched
+func (w *WatchService) WatchedDirs() []string {
+	w.dirsLock.RLock()
+	defer w.dirsLock.RUnlock()
+
+	dirs := make([]string, 0, len(w.dirs))
+	for dir := range w.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+func (w *WatchService) run(trigger *Trigger) (runID string) {
+	if w.config.MaxRuns > 0 && w.runs >= w.config.MaxRuns {
+		Logf("%s: %s skipped, the maximum of %d runs was reached", trigger.eventType(), trigger.Event.Name, w.config.MaxRuns)
+		return
+	}
+	w.runCounter++
+	runID = strconv.FormatUint(w.runCounter, 10)
+	trigger.RunID = runID
+	if w.EventTransform != nil {
+		if evt := w.EventTransform(trigger.Event); evt != nil {
+			trigger.Event = evt
+		}
+	}
+
+	if w.config.ShowMatch {
+		eventBit, _ := eventBitOf(trigger.Event)
+		msg := fmt.Sprintf("[%s] %s matched pattern \"%s\" and event \"%s\"", runID, trigger.Event.Name, trigger.Rule.pattern, eventBit.Name)
+		log.Println(ansi.Color(msg, "cyan"))
+	}
+
+	if w.config.SnapshotContent && !trigger.Dir && !trigger.Event.IsDelete() && !trigger.Event.IsRename() {
+		snapshot, err := snapshotFile(trigger.Event.Name)
+		if err != nil {
+			log.Println("cannot snapshot the file:", err)
+		} else {
+			trigger.Snapshot = snapshot
+			defer os.Remove(snapshot)
+		}
+	}
+
+	failed := false
+	w.exitCode = 0
+	for _, command := range w.commandsFor(trigger) {
+		breaker := w.getBreaker(command)
+		tripped := breaker.Tripped(time.Now())
+		if tripped && !trigger.Manual {
+			log.Println(ansi.Color(fmt.Sprintf("exec: \"%s\" is tripped, skipped", command), "yellow+b"))
+			failed = true
+			if w.exitCode == 0 {
+				w.exitCode = -1
+			}
+			if !ContinueOnError {
+				break
+			}
+			continue
+		}
+
+		startTime := time.Now()
+		err := w.executor.execute(command, trigger)
+		elapsed := time.Since(startTime)
+		w.updateStats(func(stats *Stats) {
+			stats.Commands++
+			stats.CommandTime += elapsed
+			if err != nil {
+				stats.Failures++
+			}
+		})
+		if breaker.Record(err, time.Now()) {
+			msg := fmt.Sprintf("exec: \"%s\" tripped after %d consecutive failures, paused for %s", command, w.config.FailureThreshold, w.config.FailureCooldown)
+			log.Println(ansi.Color(msg, "yellow+b"))
+		} else if tripped && err == nil {
+			log.Println(ansi.Color(fmt.Sprintf("exec: \"%s\" succeeded in a manual run, resumed", command), "yellow+b"))
+		}
+		if err != nil {
+			failed = true
+			if w.exitCode == 0 {
+				w.exitCode = exitCodeOf(err)
+			}
+			w.runOnFailure(trigger, command, err)
+			if !ContinueOnError {
+				break
+			}
 		}
 	}
+
+	if w.config.MaxRuns > 0 && !(failed && w.config.MaxRunsSuccessful) {
+		w.runs++
+		if w.runs == w.config.MaxRuns {
+			log.Printf("the maximum of %d runs was reached", w.config.MaxRuns)
+			close(w.finished)
+		}
+	}
+
+	if w.config.StateFile != "" {
+		w.flushState()
+	}
+	return
 }
 
-// Stop the WatchService
+// runOnFailure runs the OnFailure commands for the failed command of the trigger, their failures are only logged
+func (w *WatchService) runOnFailure(trigger *Trigger, command string, errCommand error) {
+	failure := *trigger
+	failure.FailedCommand, failure.ExitCode = w.executor.evaluateVariables(command, trigger), exitCodeOf(errCommand)
+	for _, hook := range w.config.OnFailure {
+		w.executor.execute(hook, &failure)
+	}
+}
+
+// commandsFor selects the commands for the trigger, delete and rename events use DeleteCommands and directory events
+// DirCommands when any are configured
+func (w *WatchService) commandsFor(trigger *Trigger) []string {
+	if trigger.Count == 0 && (trigger.Event.IsDelete() || trigger.Event.IsRename()) && len(w.config.DeleteCommands) > 0 {
+		return w.config.DeleteCommands
+	}
+	if trigger.Dir && trigger.Count == 0 && len(w.config.DirCommands) > 0 {
+		return w.config.DirCommands
+	}
+	return trigger.Rule.commands
+}
+
+func (w *WatchService) getBreaker(command string) *CircuitBreaker {
+	breaker, found := w.breakers[command]
+	if !found {
+		breaker = NewCircuitBreaker(w.config.FailureThreshold, w.config.FailureCooldown)
+		w.breakers[command] = breaker
+	}
+	return breaker
+}
+
+// Stop the WatchService started by Start
 func (w *WatchService) Stop() error {
-	return w.watcher.Close()
+	if w.cancel == nil {
+		return errors.New("the watch service was not started")
+	}
+	w.cancel()
+	return <-w.stopped
 }
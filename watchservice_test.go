@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.exp/fsnotify"
+)
+
+func TestValidateWatchFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []string
+		expected map[string]EventBit
+		hasError bool
+	}{
+		{"empty", []string{}, nil, true},
+		{"nil", nil, nil, true},
+		{"unknown", []string{"explode"}, nil, true},
+		{"unknown with valid", []string{"create", "explode"}, nil, true},
+		{"all", []string{"all"}, AllEvents, false},
+		{"all mixed case", []string{"ALL"}, AllEvents, false},
+		{"all with specific", []string{"create", "all"}, AllEvents, false},
+		{"all with attrib", []string{"all", "attrib"}, ValidEvents, false},
+		{"single", []string{"create"}, map[string]EventBit{"create": CreateEvent}, false},
+		{"mixed case", []string{"Modify", "DELETE"}, map[string]EventBit{"modify": ModifyEvent, "delete": DeleteEvent}, false},
+		{"duplicates", []string{"rename", "Rename"}, map[string]EventBit{"rename": RenameEvent}, false},
+		{"every event", []string{"create", "delete", "modify", "rename"}, AllEvents, false},
+		{"attrib", []string{"Attrib"}, map[string]EventBit{"attrib": AttribEvent}, false},
+	}
+
+	for _, test := range tests {
+		actual, err := validateWatchFlags(test.events)
+		if test.hasError {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", test.name, actual)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestNewWatchServiceErrorKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected error
+	}{
+		{"invalid event", Config{Events: CommaStringSet{"explode"}}, ErrInvalidEvent},
+		{"bad pattern", Config{Events: CommaStringSet{"all"}, IncludePattern: "(*"}, ErrBadPattern},
+		{"invalid overflow policy", Config{Events: CommaStringSet{"all"}, OnOverflow: "explode"}, ErrInvalidOption},
+	}
+
+	for _, test := range tests {
+		config := *defaultConfig
+		config.Events, config.IncludePattern = test.config.Events, test.config.IncludePattern
+		if test.config.OnOverflow != "" {
+			config.OnOverflow = test.config.OnOverflow
+		}
+
+		_, err := NewWatchService(".", &config)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected an error matching %q, got %v", test.name, test.expected, err)
+		}
+	}
+
+	if _, err := validateWatchFlags(nil); !errors.Is(err, ErrNoEvents) {
+		t.Errorf("no events: expected %q, got %v", ErrNoEvents, err)
+	}
+}
+
+func TestWaitForPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{Recursive: true, Subtrees: CommaStringSet{"build"}, WaitForPath: time.Second}
+	w := &WatchService{path: dir, config: config}
+	go func() {
+		time.Sleep(WaitForPathMinBackoff)
+		os.Mkdir(filepath.Join(dir, "build"), 0755)
+	}()
+	if err := w.waitForPaths(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	config.Subtrees = CommaStringSet{"missing"}
+	config.WaitForPath = WaitForPathMinBackoff
+	if err := w.waitForPaths(context.Background()); !os.IsNotExist(errors.Unwrap(err)) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestRelativeToRoot(t *testing.T) {
+	w := &WatchService{path: ".", dirs: make(map[string]bool)}
+	for _, path := range []string{"./src/main.go", "src/main.go", "src//main.go"} {
+		if actual, expected := w.relativeToRoot(path), filepath.Join("src", "main.go"); actual != expected {
+			t.Errorf("relativeToRoot(%q) = %q, expected %q", path, actual, expected)
+		}
+	}
+
+	w.addDir("./src")
+	if !w.isDir("src") || !w.isDir("./src/") {
+		t.Error("the directories should be found whatever the form of their path")
+	}
+}
+
+func TestCompileRulesInterval(t *testing.T) {
+	config := &Config{Interval: time.Second, Rules: []RuleConfig{
+		{Pattern: "\\.go$", Commands: StringSet{"go test"}},
+		{Pattern: "\\.md$", Commands: StringSet{"make docs"}, Interval: 5 * time.Second},
+	}}
+	rules, err := compileRules(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules[0].interval != time.Second || rules[1].interval != 5*time.Second {
+		t.Errorf("expected the intervals 1s and 5s, got %s and %s", rules[0].interval, rules[1].interval)
+	}
+
+	config.Rules = nil
+	if rules, err = compileRules(config); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].interval != time.Second {
+		t.Errorf("the flat options should form a single rule limited by -i, got %d rules", len(rules))
+	}
+}
+
+func TestCollapseLatest(t *testing.T) {
+	goRule, mdRule := &Rule{index: 0}, &Rule{index: 1}
+	w := &WatchService{}
+	w.collapseLatest(&Trigger{Event: &fsnotify.FileEvent{Name: "a.go"}, Rule: goRule})
+	w.collapseLatest(&Trigger{Event: &fsnotify.FileEvent{Name: "a.md"}, Rule: mdRule})
+	w.collapseLatest(&Trigger{Event: &fsnotify.FileEvent{Name: "b.go"}, Rule: goRule})
+
+	if len(w.latest) != 2 {
+		t.Fatalf("expected the latest trigger of each rule, got %d triggers", len(w.latest))
+	}
+	if name := w.latest[0].Event.Name; name != "b.go" {
+		t.Errorf("expected the latest event of the first rule, got %s", name)
+	}
+}
+
+func TestParseWatchTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		path   string
+	}{
+		{"", "."},
+		{"src", "src"},
+		{"file://./src", "./src"},
+		{"FILE:///var/log", "/var/log"},
+		{"file://", "."},
+		{"C:\\data", "C:\\data"},
+	}
+	for _, test := range tests {
+		scheme, path, err := parseWatchTarget(test.target)
+		if err != nil || scheme != WatchBackendFsnotify || path != test.path {
+			t.Errorf("parseWatchTarget(%q) = %q, %q, %v, expected the path %q", test.target, scheme, path, err, test.path)
+		}
+	}
+
+	if _, _, err := parseWatchTarget("poll://./nfs-mount"); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected an error matching %q, got %v", ErrInvalidOption, err)
+	}
+}
+
+func TestHandleRetargetFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.Commands = StringSet{"true"}
+	config.ExcludeExts = CommaStringSet{"lnk"}
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.handleRetarget(filepath.Join(dir, "current.lnk"))
+	if commands := w.Stats().Commands; commands != 0 {
+		t.Errorf("the excluded extension should apply to a retargeted symlink, got %d commands", commands)
+	}
+	w.handleRetarget(filepath.Join(dir, "current"))
+	if commands := w.Stats().Commands; commands != 1 {
+		t.Errorf("expected the commands to run for the retargeted symlink, got %d commands", commands)
+	}
+}
+
+func TestDispatchRuleIntervals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Rules = []RuleConfig{
+		{Pattern: "\\.go$", Commands: StringSet{"true"}},
+		{Pattern: ".*", Commands: StringSet{"true"}, Interval: time.Hour},
+	}
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := &fsnotify.FileEvent{Name: filepath.Join(dir, "main.go")}
+
+	w.dispatch(evt, w.rules)
+	if commands := w.Stats().Commands; commands != 2 {
+		t.Fatalf("both rules should run for the first event, got %d commands", commands)
+	}
+	w.dispatch(evt, w.rules)
+	if commands := w.Stats().Commands; commands != 3 {
+		t.Errorf("only the rule without interval should run again, got %d commands", commands)
+	}
+	if dropped := w.Stats().DroppedEvents; dropped != 0 {
+		t.Errorf("the event ran a rule, it should not be dropped, got %d dropped", dropped)
+	}
+
+	config.RateLimit = 0.001
+	config.Burst = 1
+	if w, err = NewWatchService(dir, &config); err != nil {
+		t.Fatal(err)
+	}
+	w.dispatch(evt, w.rules)
+	if commands := w.Stats().Commands; commands != 1 {
+		t.Errorf("the single token should run a single rule, got %d commands", commands)
+	}
+	w.dispatch(evt, w.rules)
+	if commands, dropped := w.Stats().Commands, w.Stats().DroppedEvents; commands != 1 || dropped != 1 {
+		t.Errorf("the event should be dropped by the rate limit, got %d commands and %d dropped", commands, dropped)
+	}
+}
+
+func TestRunIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Commands = StringSet{"echo changed"}
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	w.executor.Stdout = &output
+
+	evt := &fsnotify.FileEvent{Name: filepath.Join(dir, "main.go")}
+	for _, expected := range []string{"1", "2"} {
+		output.Reset()
+		runID := w.run(&Trigger{Event: evt, Rule: w.rules[0]})
+		if runID != expected {
+			t.Errorf("expected the run id %s, got %s", expected, runID)
+		}
+		if actual := output.String(); actual != "["+expected+"] changed\n" {
+			t.Errorf("expected the output prefixed by the run id %s, got %q", expected, actual)
+		}
+	}
+}
+
+func TestManualRunResumesBreaker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "ready")
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Commands = StringSet{"test -f " + marker}
+	config.FailureThreshold = 1
+	config.FailureCooldown = time.Hour
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trigger := func() *Trigger {
+		return &Trigger{Event: &fsnotify.FileEvent{Name: filepath.Join(dir, "main.go")}, Rule: w.rules[0]}
+	}
+
+	w.run(trigger())
+	w.run(trigger())
+	if commands := w.Stats().Commands; commands != 1 {
+		t.Fatalf("the tripped command should be skipped, got %d commands", commands)
+	}
+
+	if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.runManually()
+	if commands := w.Stats().Commands; commands != 2 {
+		t.Fatalf("a manual run should run the tripped command, got %d commands", commands)
+	}
+	w.run(trigger())
+	if commands := w.Stats().Commands; commands != 3 {
+		t.Errorf("the command should be resumed after a successful manual run, got %d commands", commands)
+	}
+}
+
+func TestMissingSubtree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Recursive = true
+	config.Subtrees = CommaStringSet{"src", "scr"}
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err == nil {
+		w.Stop()
+		t.Fatal("a missing subtree should fail the start")
+	}
+
+	config.AllowMissing = true
+	if w, err = NewWatchService(dir, &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("-allow-missing should watch the other subtrees, got %v", err)
+	}
+	w.Stop()
+}
+
+func TestShowEvents(t *testing.T) {
+	var output bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&output)
+
+	tests := []struct {
+		showEvents bool
+		matched    bool
+		runID      string
+		expected   string
+	}{
+		{true, true, "3", "main.go matched, run 3"},
+		{true, true, "", "main.go matched, not run"},
+		{true, false, "", "main.go not matched"},
+		{false, true, "3", ""},
+	}
+	for _, test := range tests {
+		config := *defaultConfig
+		config.NoSummary = true
+		config.ShowEvents = test.showEvents
+		w, err := NewWatchService(".", &config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output.Reset()
+		w.recordEvent(&fsnotify.FileEvent{Name: "main.go"}, test.matched, test.runID)
+		if actual := output.String(); test.expected == "" && actual != "" || !strings.Contains(actual, test.expected) {
+			t.Errorf("show %t, matched %t, run %q: expected %q, got %q", test.showEvents, test.matched, test.runID, test.expected, actual)
+		}
+	}
+}
+
+func TestWalkTreeMatchesWatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"src/pkg", "src/build/out", "tmp/cache", "docs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, IgnoreFile), []byte("build/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filterFile := filepath.Join(dir, "filters")
+	if err := ioutil.WriteFile(filterFile, []byte("exclude tmp/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Recursive = true
+	config.FilterFile = filterFile
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var walked []string
+	skipped := make(map[string]string)
+	err = w.walkTree(dir, func(path string) error {
+		walked = append(walked, path)
+		return nil
+	}, func(path string, reason string, _ error) {
+		skipped[w.relativeToRoot(path)] = reason
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSkipped := map[string]string{"src/build": "ignored by " + IgnoreFile, "tmp": "excluded by " + filterFile}
+	if !reflect.DeepEqual(skipped, expectedSkipped) {
+		t.Errorf("expected the skipped directories %q, got %q", expectedSkipped, skipped)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if watched := w.WatchedDirs(); !reflect.DeepEqual(watched, walked) {
+		t.Errorf("the tree should list the watched directories %q, got %q", watched, walked)
+	}
+}
+
+func TestLazyRecursive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"a/b/c", "a/build", "d"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, IgnoreFile), []byte("build/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := *defaultConfig
+	config.NoSummary = true
+	config.Recursive = true
+	config.LazyRecursive = true
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	tests := []struct {
+		event   string
+		watched []string
+	}{
+		{"", []string{"", "a", "d"}},
+		{"a/main.go", []string{"", "a", "a/b", "d"}},
+		{"a/main.go", []string{"", "a", "a/b", "d"}},
+		{"a/b/c/main.go", []string{"", "a", "a/b", "d"}},
+		{"a/b/main.go", []string{"", "a", "a/b", "a/b/c", "d"}},
+	}
+	for _, test := range tests {
+		if test.event != "" {
+			w.syncWatchersAndCaches(&fsnotify.FileEvent{Name: filepath.Join(dir, test.event)})
+		}
+		var expected []string
+		for _, rel := range test.watched {
+			expected = append(expected, filepath.Join(dir, rel))
+		}
+		if watched := w.WatchedDirs(); !reflect.DeepEqual(watched, expected) {
+			t.Errorf("after %q: expected the watched directories %q, got %q", test.event, expected, watched)
+		}
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- w.StartContext(ctx)
+	}()
+
+	select {
+	case <-w.Ready():
+	case err := <-stopped:
+		t.Fatalf("the service should run until the context is cancelled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the service was not started")
+	}
+	for i := 0; i < 10; i++ {
+		if err := w.Inject(&fsnotify.FileEvent{Name: filepath.Join(dir, "main.go")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("expected a clean stop, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the cancelled context should stop the service")
+	}
+	if events := w.Stats().Events; events != 10 {
+		t.Errorf("the pending events should be drained before returning, got %d events", events)
+	}
+	select {
+	case <-w.workerDone:
+	default:
+		t.Error("the worker should be stopped")
+	}
+}
+
+func TestInject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := *defaultConfig
+	config.NoSummary = true
+	w, err := NewWatchService(dir, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := &fsnotify.FileEvent{Name: filepath.Join(dir, "main.go")}
+	if err := w.Inject(evt); err == nil {
+		t.Error("expected an error before the service is started")
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Inject(evt); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); w.Stats().Events == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if events := w.Stats().Events; events != 1 {
+		t.Errorf("expected the injected event to be processed, got %d events", events)
+	}
+
+	w.Stop()
+	if err := w.Inject(evt); err == nil {
+		t.Error("expected an error once the service is stopped")
+	}
+}